@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// asset类型的配置,assetRoot为空时不检查
+var assetRoot string
+var assetExts []string
+
+// 检查结果,修改配置时清空
+var assetCache sync.Map
+
+// 检查资源文件是否存在,s可以省略扩展名
+func checkAsset(s string) error {
+	if assetRoot == "" || s == "" {
+		return nil
+	}
+	if ok, found := assetCache.Load(s); found {
+		if !ok.(bool) {
+			return fmt.Errorf("asset not found:%s", s)
+		}
+		return nil
+	}
+	ok := false
+	for _, ext := range append([]string{""}, assetExts...) {
+		if f, err := os.Stat(filepath.Join(assetRoot, s+ext)); err == nil && !f.IsDir() {
+			ok = true
+			break
+		}
+	}
+	assetCache.Store(s, ok)
+	if !ok {
+		return fmt.Errorf("asset not found:%s", s)
+	}
+	return nil
+}
+
+func setAssetConfig(root string, exts string) {
+	assetCache.Range(func(k, _ interface{}) bool {
+		assetCache.Delete(k)
+		return true
+	})
+	assetRoot = root
+	assetExts = nil
+	for _, v := range strings.Split(exts, ",") {
+		if v = trim(v); v != "" {
+			if !strings.HasPrefix(v, ".") {
+				v = "." + v
+			}
+			assetExts = append(assetExts, v)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// 列属性const,该列的值作为常量名,go模式生成"表名+值"为名字,id为值的常量,空单元格不生成
+const constAttr = "const"
+
+var constPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// 常量名,GOLD为TableGold,BIG_GOLD为TableBigGold,bigGold为TableBigGold
+func constName(table, value string) string {
+	var b strings.Builder
+	b.WriteString(title(table))
+	for _, s := range strings.Split(value, "_") {
+		if s == strings.ToUpper(s) {
+			s = strings.ToLower(s)
+		}
+		b.WriteString(title(s))
+	}
+	return b.String()
+}
+
+// 检查const列的值是否是合法的标识符,生成的常量名在所有表中是否重复,是否与go模式生成的其它标识符重名
+func (w *Walker) checkConsts(tables []*Table) {
+	var generated map[string]bool
+	if w.funcNames != nil {
+		generated = w.funcNames(tables)
+		if w.i18n != nil && w.i18n.mode == "go" {
+			generated["I18n"] = true
+			generated["LoadI18nFromFile"] = true
+		}
+	}
+	type define struct {
+		table string
+		line  int
+	}
+	names := map[string]define{}
+	for _, t := range tables {
+		for i, field := range t.fields {
+			if _, ok := field.attr(constAttr); !ok || field.parser == nil {
+				continue
+			}
+			for _, r := range t.rows {
+				v := r.values[i]
+				if v == nil || v.value.(string) == "" {
+					continue
+				}
+				s := v.value.(string)
+				if !constPattern.MatchString(s) {
+					w.report.Errorf(cellPos(t.file, r.line, i), "const: %s is not a valid identifier", s)
+					continue
+				}
+				name := constName(t.name, s)
+				if generated[name] {
+					w.report.Errorf(cellPos(t.file, r.line, i), "const: name %s conflicts with a generated identifier", name)
+					continue
+				}
+				if d, ok := names[name]; ok {
+					if d.table == t.name {
+						w.report.Errorf(cellPos(t.file, r.line, i), "const: duplicate name %s first defined at row %d", name, d.line)
+					} else {
+						w.report.Errorf(cellPos(t.file, r.line, i), "const: duplicate name %s first defined in table %s row %d", name, d.table, d.line)
+					}
+					continue
+				}
+				names[name] = define{t.name, r.line}
+			}
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 带约束的类型,约束写在类型之后:
+//
+//	int(1..100)  取值范围,string为长度,数组为元素个数,上下限均可省略
+//	string!      不能为空
+//	int unique   同一列中的值不能重复
+//	string~^icon_ 必须匹配正则,作用于string或string数组的每个元素,必须写在最后
+//
+// 空单元格只检查!约束
+type ConstraintParser struct {
+	Parser
+	hasMin   bool
+	hasMax   bool
+	min      float64
+	max      float64
+	required bool
+	unique   bool
+	regexp   *regexp.Regexp
+	seen     map[string]bool
+}
+
+// 去掉约束,返回实际的类型解析器
+func baseParser(p Parser) Parser {
+	if c, ok := p.(*ConstraintParser); ok {
+		return c.Parser
+	}
+	return p
+}
+
+func isEmptyValue(s string) bool {
+	s = trim(s)
+	return s == "" || s == "[]" || s == "{}"
+}
+
+// 查找不在括号中的c
+func indexTopLevel(s string, c byte) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{', '(':
+			depth++
+		case '}', ')':
+			depth--
+		case c:
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func parseRange(c *ConstraintParser, s string) error {
+	v := strings.Split(s, "..")
+	if len(v) != 2 {
+		return fmt.Errorf("invaild range:%s", s)
+	}
+	var err error
+	if min := trim(v[0]); min != "" {
+		c.hasMin = true
+		if c.min, err = strconv.ParseFloat(min, 64); err != nil {
+			return fmt.Errorf("invaild range:%s", s)
+		}
+	}
+	if max := trim(v[1]); max != "" {
+		c.hasMax = true
+		if c.max, err = strconv.ParseFloat(max, 64); err != nil {
+			return fmt.Errorf("invaild range:%s", s)
+		}
+	}
+	return nil
+}
+
+// 从类型定义中分离出约束,没有约束时返回nil
+func splitConstraint(s string) (string, *ConstraintParser, error) {
+	c := &ConstraintParser{}
+	found := false
+	if i := indexTopLevel(s, '~'); i >= 0 {
+		re, err := regexp.Compile(s[i+1:])
+		if err != nil {
+			return "", nil, err
+		}
+		c.regexp = re
+		found = true
+		s = trim(s[:i])
+	}
+
+	for {
+		if strings.HasSuffix(s, "unique") {
+			c.unique = true
+			s = trim(strings.TrimSuffix(s, "unique"))
+		} else if strings.HasSuffix(s, "!") {
+			c.required = true
+			s = trim(strings.TrimSuffix(s, "!"))
+		} else if l := strings.LastIndex(s, "("); l > 0 && strings.HasSuffix(s, ")") {
+			if err := parseRange(c, s[l+1:len(s)-1]); err != nil {
+				return "", nil, err
+			}
+			s = trim(s[:l])
+		} else {
+			break
+		}
+		found = true
+	}
+
+	if found {
+		return s, c, nil
+	} else {
+		return s, nil, nil
+	}
+}
+
+// 检查正则约束能否作用于p
+func regexpApplicable(p Parser) bool {
+	switch pp := baseParser(p).(type) {
+	case *ArrayParser:
+		return regexpApplicable(pp.elements)
+	default:
+		return pp.ValueType() == typeString
+	}
+}
+
+func (c *ConstraintParser) checkRange(v *Value) error {
+	var n float64
+	switch v.valueType {
+	case typeInt:
+		n = float64(v.value.(int64))
+	case typeFloat:
+		n = v.value.(float64)
+	case typeString:
+		n = float64(utf8.RuneCountInString(v.value.(string)))
+	case typeArray:
+		n = float64(len(v.value.(*Array).value))
+	default:
+		return errors.New("range constraint not supported")
+	}
+	if (c.hasMin && n < c.min) || (c.hasMax && n > c.max) {
+		return fmt.Errorf("%v out of range", n)
+	}
+	return nil
+}
+
+func (c *ConstraintParser) checkRegexp(v *Value) error {
+	switch v.valueType {
+	case typeString:
+		if !c.regexp.MatchString(v.value.(string)) {
+			return fmt.Errorf("%s not match %s", v.value, c.regexp)
+		}
+	case typeArray:
+		for _, vv := range v.value.(*Array).value {
+			if err := c.checkRegexp(vv); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *ConstraintParser) Parse(s string) (*Value, error) {
+	if isEmptyValue(s) {
+		if c.required {
+			return nil, errors.New("value required")
+		}
+		return c.Parser.Parse(s)
+	}
+
+	v, err := c.Parser.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.hasMin || c.hasMax {
+		if err = c.checkRange(v); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.regexp != nil {
+		if err = c.checkRegexp(v); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.unique {
+		var b strings.Builder
+		v.ToJsonString(&b)
+		if c.seen[b.String()] {
+			return nil, fmt.Errorf("duplicate value %s", b.String())
+		}
+		c.seen[b.String()] = true
+	}
+
+	return v, nil
+}
+
+func makeConstraintParser(s string, c *ConstraintParser) (Parser, error) {
+	var err error
+	if c.Parser, err = MakeParser(s); err != nil {
+		return nil, err
+	}
+	if c.hasMin || c.hasMax {
+		switch c.Parser.ValueType() {
+		case typeInt, typeFloat, typeString, typeArray:
+		default:
+			return nil, fmt.Errorf("range constraint on invaild type:%s", s)
+		}
+	}
+	if c.regexp != nil && !regexpApplicable(c.Parser) {
+		return nil, fmt.Errorf("regexp constraint on non-string type:%s", s)
+	}
+	if c.unique {
+		c.seen = map[string]bool{}
+	}
+	return c, nil
+}
+
+// 去掉unique约束,保留其它约束
+func withoutUnique(p Parser) Parser {
+	c, ok := p.(*ConstraintParser)
+	if !ok || !c.unique {
+		return p
+	}
+	cc := *c
+	cc.unique = false
+	cc.seen = nil
+	return &cc
+}
+
+// 唯一索引列不能为空且不能重复
+func requireUnique(p Parser) Parser {
+	c, ok := p.(*ConstraintParser)
+	if !ok {
+		c = &ConstraintParser{Parser: p}
+	}
+	c.required = true
+	if !c.unique {
+		c.unique = true
+		c.seen = map[string]bool{}
+	}
+	return c
+}
@@ -8,7 +8,7 @@ import (
 
 // go读取json输出文件
 func main() {
-	err := test.LoadModelFromFile("./test/Model.json")
+	err := test.LoadAll("./test")
 	if err != nil {
 		panic(err)
 	}
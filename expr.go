@@ -0,0 +1,484 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// 简单的表达式语言,支持:
+//
+//	字面量     1 1.5 "str" 'str' true false
+//	变量       name a.b a[0]
+//	函数调用   fn(a,b)
+//	运算符     ! - * / % + - < <= > >= == != && ||
+//
+// 数字统一为float64,变量和函数由exprEnv提供
+type exprEnv interface {
+	lookup(name string) (interface{}, error)
+	call(name string, args []expr) (interface{}, error)
+}
+
+type expr interface {
+	eval(env exprEnv) (interface{}, error)
+}
+
+type literalExpr struct {
+	value interface{}
+}
+
+type identExpr struct {
+	name string
+}
+
+type selectorExpr struct {
+	x    expr
+	name string
+}
+
+type indexExpr struct {
+	x     expr
+	index expr
+}
+
+type callExpr struct {
+	name string
+	args []expr
+}
+
+type unaryExpr struct {
+	op string
+	x  expr
+}
+
+type binaryExpr struct {
+	op string
+	l  expr
+	r  expr
+}
+
+func (e *literalExpr) eval(env exprEnv) (interface{}, error) {
+	return e.value, nil
+}
+
+func (e *identExpr) eval(env exprEnv) (interface{}, error) {
+	return env.lookup(e.name)
+}
+
+func (e *selectorExpr) eval(env exprEnv) (interface{}, error) {
+	x, err := e.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := x.(map[string]interface{}); ok {
+		return m[e.name], nil
+	}
+	return nil, fmt.Errorf("%v has no field %s", x, e.name)
+}
+
+func (e *indexExpr) eval(env exprEnv) (interface{}, error) {
+	x, err := e.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	i, err := e.index.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	a, ok1 := x.([]interface{})
+	n, ok2 := i.(float64)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("invaild index %v[%v]", x, i)
+	} else if int(n) < 0 || int(n) >= len(a) {
+		return nil, fmt.Errorf("index out of range %v[%v]", x, i)
+	}
+	return a[int(n)], nil
+}
+
+func (e *callExpr) eval(env exprEnv) (interface{}, error) {
+	return env.call(e.name, e.args)
+}
+
+func (e *unaryExpr) eval(env exprEnv) (interface{}, error) {
+	x, err := e.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "!":
+		if b, ok := x.(bool); ok {
+			return !b, nil
+		}
+	case "-":
+		if n, ok := x.(float64); ok {
+			return -n, nil
+		}
+	}
+	return nil, fmt.Errorf("invaild operand %s%v", e.op, x)
+}
+
+func (e *binaryExpr) eval(env exprEnv) (interface{}, error) {
+	l, err := e.l.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	//短路求值
+	if e.op == "&&" || e.op == "||" {
+		lb, ok := l.(bool)
+		if !ok {
+			return nil, fmt.Errorf("invaild operand %v %s", l, e.op)
+		}
+		if (e.op == "&&" && !lb) || (e.op == "||" && lb) {
+			return lb, nil
+		}
+		r, err := e.r.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		if rb, ok := r.(bool); ok {
+			return rb, nil
+		}
+		return nil, fmt.Errorf("invaild operand %s %v", e.op, r)
+	}
+
+	r, err := e.r.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case "==":
+		return reflect.DeepEqual(l, r), nil
+	case "!=":
+		return !reflect.DeepEqual(l, r), nil
+	}
+
+	if ls, ok := l.(string); ok {
+		if rs, ok := r.(string); ok {
+			switch e.op {
+			case "+":
+				return ls + rs, nil
+			case "<":
+				return ls < rs, nil
+			case "<=":
+				return ls <= rs, nil
+			case ">":
+				return ls > rs, nil
+			case ">=":
+				return ls >= rs, nil
+			}
+		}
+	}
+
+	ln, ok1 := l.(float64)
+	rn, ok2 := r.(float64)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("invaild operand %v %s %v", l, e.op, r)
+	}
+
+	switch e.op {
+	case "+":
+		return ln + rn, nil
+	case "-":
+		return ln - rn, nil
+	case "*":
+		return ln * rn, nil
+	case "/":
+		if rn == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return ln / rn, nil
+	case "%":
+		if int64(rn) == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return float64(int64(ln) % int64(rn)), nil
+	case "<":
+		return ln < rn, nil
+	case "<=":
+		return ln <= rn, nil
+	case ">":
+		return ln > rn, nil
+	case ">=":
+		return ln >= rn, nil
+	}
+	return nil, fmt.Errorf("invaild operator %s", e.op)
+}
+
+const (
+	tokEOF = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind int
+	text string
+}
+
+var exprOps []string = []string{
+	"&&", "||", "==", "!=", "<=", ">=",
+	"!", "-", "+", "*", "/", "%", "<", ">", "(", ")", ",", ".", "[", "]",
+}
+
+func isIdentChar(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case isFilterChar(c):
+			i++
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(s) && ((s[j] >= '0' && s[j] <= '9') || s[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{tokNumber, s[i:j]})
+			i = j
+		case c == '"' || c == '\'':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(s) && s[j] != c; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				b.WriteByte(s[j])
+			}
+			if j == len(s) {
+				return nil, fmt.Errorf("unterminated string:%s", s[i:])
+			}
+			tokens = append(tokens, token{tokString, b.String()})
+			i = j + 1
+		case isIdentChar(c, true):
+			j := i
+			for j < len(s) && isIdentChar(s[j], false) {
+				j++
+			}
+			tokens = append(tokens, token{tokIdent, s[i:j]})
+			i = j
+		default:
+			found := false
+			for _, op := range exprOps {
+				if strings.HasPrefix(s[i:], op) {
+					tokens = append(tokens, token{tokOp, op})
+					i += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("invaild char '%c' in expression", c)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokEOF}), nil
+}
+
+var binaryPrecedence map[string]int = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3,
+	"<": 4, "<=": 4, ">": 4, ">=": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+type exprParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) expect(op string) error {
+	if t := p.next(); t.kind != tokOp || t.text != op {
+		return fmt.Errorf("expect '%s' got '%s'", op, t.text)
+	}
+	return nil
+}
+
+func (p *exprParser) parseBinary(prec int) (expr, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		tprec, ok := binaryPrecedence[t.text]
+		if t.kind != tokOp || !ok || tprec < prec {
+			return l, nil
+		}
+		p.next()
+		r, err := p.parseBinary(tprec + 1)
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryExpr{op: t.text, l: l, r: r}
+	}
+}
+
+func (p *exprParser) parseUnary() (expr, error) {
+	if t := p.peek(); t.kind == tokOp && (t.text == "!" || t.text == "-") {
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: t.text, x: x}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *exprParser) parsePostfix() (expr, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokOp {
+			return x, nil
+		}
+		switch t.text {
+		case ".":
+			p.next()
+			name := p.next()
+			if name.kind != tokIdent {
+				return nil, fmt.Errorf("expect field name got '%s'", name.text)
+			}
+			x = &selectorExpr{x: x, name: name.text}
+		case "[":
+			p.next()
+			index, err := p.parseBinary(1)
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = &indexExpr{x: x, index: index}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *exprParser) parsePrimary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &literalExpr{value: n}, nil
+	case tokString:
+		return &literalExpr{value: t.text}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return &literalExpr{value: true}, nil
+		case "false":
+			return &literalExpr{value: false}, nil
+		}
+		if n := p.peek(); n.kind == tokOp && n.text == "(" {
+			p.next()
+			call := &callExpr{name: t.text}
+			if n := p.peek(); n.kind == tokOp && n.text == ")" {
+				p.next()
+				return call, nil
+			}
+			for {
+				arg, err := p.parseBinary(1)
+				if err != nil {
+					return nil, err
+				}
+				call.args = append(call.args, arg)
+				if n := p.next(); n.kind == tokOp && n.text == ")" {
+					return call, nil
+				} else if n.kind != tokOp || n.text != "," {
+					return nil, fmt.Errorf("expect ',' or ')' got '%s'", n.text)
+				}
+			}
+		}
+		return &identExpr{name: t.text}, nil
+	case tokOp:
+		if t.text == "(" {
+			x, err := p.parseBinary(1)
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect(")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		}
+	}
+	if t.kind == tokEOF {
+		return nil, errors.New("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected '%s'", t.text)
+}
+
+func parseExpr(s string) (expr, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	e, err := p.parseBinary(1)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", s, err)
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("%s: unexpected '%s'", s, t.text)
+	}
+	return e, nil
+}
+
+// 把Value转换成表达式中使用的值
+func (v *Value) toExprValue() interface{} {
+	switch v.valueType {
+	case typeInt:
+		switch n := v.value.(type) {
+		case int64:
+			return float64(n)
+		case int:
+			return float64(n)
+		}
+	case typeFloat:
+		return v.value.(float64)
+	case typeArray:
+		a := []interface{}{}
+		for _, vv := range v.value.(*Array).value {
+			a = append(a, vv.toExprValue())
+		}
+		return a
+	case typeStruct:
+		m := map[string]interface{}{}
+		for _, f := range v.value.(*Struct).fields {
+			m[f.name] = f.value.toExprValue()
+		}
+		return m
+	}
+	return v.value
+}
@@ -0,0 +1,755 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/360EntSecGroup-Skylar/excelize"
+)
+
+// 公式单元格,excelize读取到的是excel保存的缓存值,
+// 对于没有重新计算过的表(例如由脚本生成),缓存值可能为空或已经过期。
+// 这里对常用的公式求值,缓存值缺失时使用求值结果,与缓存值不一致时报告警告。
+type formulaCell struct {
+	text string //公式,不包含=
+	dr   int    //共享公式相对于主单元格的偏移
+	dc   int
+}
+
+// excel中的错误值,如#DIV/0!
+type formulaError string
+
+type cellRange struct {
+	r1, c1, r2, c2 int
+}
+
+var errUnsupportedFormula = errors.New("unsupported formula")
+
+type formulaEval struct {
+	rows     [][]string
+	formulas map[[2]int]*formulaCell
+	values   map[[2]int]interface{}
+	visiting map[[2]int]bool
+}
+
+// 读取sheet中所有公式,key为(行下标,列下标),均从0开始,part为sheetPart返回的文件
+func sheetFormulas(xlsx *excelize.File, part string) map[[2]int]*formulaCell {
+	ws := xlsx.Sheet[part]
+	if ws == nil {
+		return nil
+	}
+
+	type master struct {
+		text     string
+		row, col int
+	}
+	masters := map[string]master{}
+	for _, row := range ws.SheetData.Row {
+		for _, c := range row.C {
+			if c.F != nil && c.F.T == "shared" && c.F.Ref != "" {
+				masters[c.F.Si] = master{c.F.Content, row.R - 1, cellCol(c.R)}
+			}
+		}
+	}
+
+	formulas := map[[2]int]*formulaCell{}
+	for _, row := range ws.SheetData.Row {
+		for _, c := range row.C {
+			if c.F == nil {
+				continue
+			}
+			r, col := row.R-1, cellCol(c.R)
+			if c.F.T == "shared" && c.F.Ref == "" {
+				if m, ok := masters[c.F.Si]; ok {
+					formulas[[2]int{r, col}] = &formulaCell{text: m.text, dr: r - m.row, dc: col - m.col}
+				}
+			} else if c.F.Content != "" {
+				formulas[[2]int{r, col}] = &formulaCell{text: c.F.Content}
+			}
+		}
+	}
+	return formulas
+}
+
+func cellCol(axis string) int {
+	return excelize.TitleToNumber(strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') {
+			return r
+		}
+		return -1
+	}, axis))
+}
+
+func formatFormulaValue(v interface{}) string {
+	switch vv := v.(type) {
+	case float64:
+		if vv == math.Trunc(vv) && math.Abs(vv) < 1e15 {
+			return strconv.FormatInt(int64(vv), 10)
+		}
+		return strconv.FormatFloat(vv, 'f', -1, 64)
+	case bool:
+		if vv {
+			return "TRUE"
+		}
+		return "FALSE"
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(vv)
+	}
+}
+
+// 缓存值与求值结果是否一致
+func sameFormulaValue(cached string, v interface{}) bool {
+	switch vv := v.(type) {
+	case float64:
+		n, err := strconv.ParseFloat(cached, 64)
+		return err == nil && math.Abs(n-vv) <= 1e-9*math.Max(1, math.Abs(vv))
+	case bool:
+		b, err := strconv.ParseBool(cached)
+		return err == nil && b == vv
+	default:
+		return cached == formatFormulaValue(v)
+	}
+}
+
+// 对所有公式单元格求值,结果写回rows
+func (w *Walker) evalFormulas(file string, rows [][]string, formulas map[[2]int]*formulaCell) {
+	e := &formulaEval{
+		rows:     rows,
+		formulas: formulas,
+		values:   map[[2]int]interface{}{},
+		visiting: map[[2]int]bool{},
+	}
+	for k, f := range formulas {
+		r, c := k[0], k[1]
+		if r >= len(rows) || c >= len(rows[r]) {
+			continue
+		}
+		cached := rows[r][c]
+		pos := cellPos(file, r+1, c)
+		v, err := e.cell(r, c)
+		if fe, ok := v.(formulaError); ok && err == nil {
+			err = errors.New(string(fe))
+		}
+		if errors.Is(err, errUnsupportedFormula) {
+			//不能确定缓存值是否正确,有缓存值时使用缓存值
+			if cached == "" {
+				w.report.Errorf(pos, "formula has no cached value and can not be evaluated:(=%s) %v", f.text, err)
+			} else {
+				w.report.Warnf(pos, "formula can not be evaluated, use cached value:(=%s) %v", f.text, err)
+			}
+		} else if err != nil {
+			if cached == "" {
+				w.report.Warnf(pos, "formula has no cached value and can not be evaluated:(=%s) %v", f.text, err)
+			}
+		} else if cached == "" {
+			if v != nil {
+				w.report.Warnf(pos, "formula has no cached value, use evaluated value:(=%s) %s", f.text, formatFormulaValue(v))
+			}
+			rows[r][c] = formatFormulaValue(v)
+		} else if !sameFormulaValue(cached, v) {
+			w.report.Warnf(pos, "formula cached value is stale, use evaluated value:(=%s) cached:%s evaluated:%s", f.text, cached, formatFormulaValue(v))
+			rows[r][c] = formatFormulaValue(v)
+		}
+	}
+}
+
+// 单元格的值,公式单元格返回求值结果
+func (e *formulaEval) cell(r, c int) (interface{}, error) {
+	k := [2]int{r, c}
+	if f, ok := e.formulas[k]; ok {
+		if v, ok := e.values[k]; ok {
+			return v, nil
+		}
+		if e.visiting[k] {
+			return nil, errors.New("circular reference")
+		}
+		e.visiting[k] = true
+		v, err := e.eval(f)
+		delete(e.visiting, k)
+		if err != nil {
+			return nil, err
+		}
+		e.values[k] = v
+		return v, nil
+	}
+
+	if r < 0 || r >= len(e.rows) || c < 0 || c >= len(e.rows[r]) || e.rows[r][c] == "" {
+		return nil, nil
+	}
+	s := e.rows[r][c]
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return n, nil
+	}
+	return s, nil
+}
+
+func (e *formulaEval) eval(f *formulaCell) (interface{}, error) {
+	p := &formulaParser{e: e, s: f.text, dr: f.dr, dc: f.dc}
+	v, err := p.parseCompare()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.i < len(p.s) {
+		return nil, errUnsupportedFormula
+	}
+	if rg, ok := v.(cellRange); ok {
+		//单个单元格的区域
+		if rg.r1 == rg.r2 && rg.c1 == rg.c2 {
+			return e.cell(rg.r1, rg.c1)
+		}
+		return nil, errUnsupportedFormula
+	}
+	return v, nil
+}
+
+// 边解析边求值
+type formulaParser struct {
+	e      *formulaEval
+	s      string
+	i      int
+	dr, dc int
+}
+
+func (p *formulaParser) skipSpace() {
+	for p.i < len(p.s) && p.s[p.i] == ' ' {
+		p.i++
+	}
+}
+
+func (p *formulaParser) peekOp(ops ...string) string {
+	p.skipSpace()
+	for _, op := range ops {
+		if strings.HasPrefix(p.s[p.i:], op) {
+			return op
+		}
+	}
+	return ""
+}
+
+func toNumber(v interface{}) (float64, interface{}) {
+	switch vv := v.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return vv, nil
+	case bool:
+		if vv {
+			return 1, nil
+		}
+		return 0, nil
+	case formulaError:
+		return 0, vv
+	case string:
+		if n, err := strconv.ParseFloat(vv, 64); err == nil {
+			return n, nil
+		}
+	}
+	return 0, formulaError("#VALUE!")
+}
+
+func (p *formulaParser) value(v interface{}) (interface{}, error) {
+	if rg, ok := v.(cellRange); ok {
+		if rg.r1 == rg.r2 && rg.c1 == rg.c2 {
+			return p.e.cell(rg.r1, rg.c1)
+		}
+		return nil, errUnsupportedFormula
+	}
+	return v, nil
+}
+
+func (p *formulaParser) parseCompare() (interface{}, error) {
+	l, err := p.parseConcat()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peekOp("<>", "<=", ">=", "=", "<", ">")
+		if op == "" {
+			return l, nil
+		}
+		p.i += len(op)
+		r, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		if l, err = p.value(l); err != nil {
+			return nil, err
+		}
+		if r, err = p.value(r); err != nil {
+			return nil, err
+		}
+		var cmp int
+		ls, lok := l.(string)
+		rs, rok := r.(string)
+		if lok && rok {
+			cmp = strings.Compare(strings.ToLower(ls), strings.ToLower(rs))
+		} else {
+			ln, le := toNumber(l)
+			rn, re := toNumber(r)
+			if le != nil {
+				l = le
+				continue
+			} else if re != nil {
+				l = re
+				continue
+			}
+			if ln < rn {
+				cmp = -1
+			} else if ln > rn {
+				cmp = 1
+			}
+		}
+		switch op {
+		case "=":
+			l = cmp == 0
+		case "<>":
+			l = cmp != 0
+		case "<":
+			l = cmp < 0
+		case "<=":
+			l = cmp <= 0
+		case ">":
+			l = cmp > 0
+		case ">=":
+			l = cmp >= 0
+		}
+	}
+}
+
+func (p *formulaParser) parseConcat() (interface{}, error) {
+	l, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekOp("&") != "" {
+		p.i++
+		r, err := p.parseAdd()
+		if err != nil {
+			return nil, err
+		}
+		if l, err = p.value(l); err != nil {
+			return nil, err
+		}
+		if r, err = p.value(r); err != nil {
+			return nil, err
+		}
+		l = formatFormulaValue(l) + formatFormulaValue(r)
+	}
+	return l, nil
+}
+
+func (p *formulaParser) arith(op string, l, r interface{}) (interface{}, error) {
+	var err error
+	if l, err = p.value(l); err != nil {
+		return nil, err
+	}
+	if r, err = p.value(r); err != nil {
+		return nil, err
+	}
+	ln, le := toNumber(l)
+	rn, re := toNumber(r)
+	if le != nil {
+		return le, nil
+	} else if re != nil {
+		return re, nil
+	}
+	switch op {
+	case "+":
+		return ln + rn, nil
+	case "-":
+		return ln - rn, nil
+	case "*":
+		return ln * rn, nil
+	case "/":
+		if rn == 0 {
+			return formulaError("#DIV/0!"), nil
+		}
+		return ln / rn, nil
+	case "^":
+		return math.Pow(ln, rn), nil
+	}
+	return nil, errUnsupportedFormula
+}
+
+func (p *formulaParser) parseAdd() (interface{}, error) {
+	l, err := p.parseMul()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peekOp("+", "-")
+		if op == "" {
+			return l, nil
+		}
+		p.i++
+		r, err := p.parseMul()
+		if err != nil {
+			return nil, err
+		}
+		if l, err = p.arith(op, l, r); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *formulaParser) parseMul() (interface{}, error) {
+	l, err := p.parsePow()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peekOp("*", "/")
+		if op == "" {
+			return l, nil
+		}
+		p.i++
+		r, err := p.parsePow()
+		if err != nil {
+			return nil, err
+		}
+		if l, err = p.arith(op, l, r); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *formulaParser) parsePow() (interface{}, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekOp("^") != "" {
+		p.i++
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if l, err = p.arith("^", l, r); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (p *formulaParser) parseUnary() (interface{}, error) {
+	if op := p.peekOp("-", "+"); op != "" {
+		p.i++
+		v, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if op == "-" {
+			return p.arith("-", 0.0, v)
+		}
+		return p.value(v)
+	}
+	v, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if p.peekOp("%") != "" {
+		p.i++
+		return p.arith("/", v, 100.0)
+	}
+	return v, nil
+}
+
+// 读取单元格引用,如A1 $A$1,返回(行,列)
+func (p *formulaParser) readRef() (int, int, bool) {
+	i := p.i
+	absCol := false
+	if i < len(p.s) && p.s[i] == '$' {
+		absCol = true
+		i++
+	}
+	cs := i
+	for i < len(p.s) && p.s[i] >= 'A' && p.s[i] <= 'Z' {
+		i++
+	}
+	if i == cs || i-cs > 3 {
+		return 0, 0, false
+	}
+	col := excelize.TitleToNumber(p.s[cs:i])
+	absRow := false
+	if i < len(p.s) && p.s[i] == '$' {
+		absRow = true
+		i++
+	}
+	rs := i
+	for i < len(p.s) && p.s[i] >= '0' && p.s[i] <= '9' {
+		i++
+	}
+	if i == rs || (i < len(p.s) && (p.s[i] == '(' || p.s[i] == '!' || isIdentChar(p.s[i], false))) {
+		return 0, 0, false
+	}
+	row, _ := strconv.Atoi(p.s[rs:i])
+	row--
+	if !absRow {
+		row += p.dr
+	}
+	if !absCol {
+		col += p.dc
+	}
+	p.i = i
+	return row, col, true
+}
+
+func (p *formulaParser) parsePrimary() (interface{}, error) {
+	p.skipSpace()
+	if p.i >= len(p.s) {
+		return nil, errUnsupportedFormula
+	}
+	c := p.s[p.i]
+	switch {
+	case c == '(':
+		p.i++
+		v, err := p.parseCompare()
+		if err != nil {
+			return nil, err
+		}
+		if p.peekOp(")") == "" {
+			return nil, errUnsupportedFormula
+		}
+		p.i++
+		return v, nil
+	case c == '"':
+		var b strings.Builder
+		for p.i++; p.i < len(p.s); p.i++ {
+			if p.s[p.i] == '"' {
+				if p.i+1 < len(p.s) && p.s[p.i+1] == '"' {
+					b.WriteByte('"')
+					p.i++
+				} else {
+					p.i++
+					return b.String(), nil
+				}
+			} else {
+				b.WriteByte(p.s[p.i])
+			}
+		}
+		return nil, errUnsupportedFormula
+	case (c >= '0' && c <= '9') || c == '.':
+		j := p.i
+		for j < len(p.s) && ((p.s[j] >= '0' && p.s[j] <= '9') || p.s[j] == '.' || p.s[j] == 'E' ||
+			((p.s[j] == '+' || p.s[j] == '-') && p.s[j-1] == 'E')) {
+			j++
+		}
+		n, err := strconv.ParseFloat(p.s[p.i:j], 64)
+		if err != nil {
+			return nil, errUnsupportedFormula
+		}
+		p.i = j
+		return n, nil
+	}
+
+	if r1, c1, ok := p.readRef(); ok {
+		rg := cellRange{r1, c1, r1, c1}
+		if p.i < len(p.s) && p.s[p.i] == ':' {
+			p.i++
+			r2, c2, ok := p.readRef()
+			if !ok {
+				return nil, errUnsupportedFormula
+			}
+			rg.r2, rg.c2 = r2, c2
+		}
+		return rg, nil
+	}
+
+	j := p.i
+	for j < len(p.s) && (isIdentChar(p.s[j], false) || p.s[j] == '.') {
+		j++
+	}
+	name := strings.ToUpper(p.s[p.i:j])
+	p.i = j
+	if p.peekOp("(") == "" {
+		switch name {
+		case "TRUE":
+			return true, nil
+		case "FALSE":
+			return false, nil
+		}
+		return nil, errUnsupportedFormula
+	}
+	p.i++
+	var args []interface{}
+	if p.peekOp(")") == "" {
+		for {
+			v, err := p.parseCompare()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, v)
+			if p.peekOp(",") != "" {
+				p.i++
+			} else if p.peekOp(")") != "" {
+				break
+			} else {
+				return nil, errUnsupportedFormula
+			}
+		}
+	}
+	p.i++
+	return p.call(name, args)
+}
+
+// 展开参数中的区域,只保留数字
+func (p *formulaParser) numbers(args []interface{}) ([]float64, interface{}, error) {
+	var numbers []float64
+	for _, a := range args {
+		if rg, ok := a.(cellRange); ok {
+			for r := rg.r1; r <= rg.r2; r++ {
+				for c := rg.c1; c <= rg.c2; c++ {
+					v, err := p.e.cell(r, c)
+					if err != nil {
+						return nil, nil, err
+					}
+					switch vv := v.(type) {
+					case float64:
+						numbers = append(numbers, vv)
+					case formulaError:
+						return nil, vv, nil
+					}
+				}
+			}
+		} else {
+			n, fe := toNumber(a)
+			if fe != nil {
+				return nil, fe, nil
+			}
+			numbers = append(numbers, n)
+		}
+	}
+	return numbers, nil, nil
+}
+
+func (p *formulaParser) call(name string, args []interface{}) (interface{}, error) {
+	switch name {
+	case "SUM", "MIN", "MAX", "AVERAGE", "COUNT":
+		numbers, fe, err := p.numbers(args)
+		if err != nil || fe != nil {
+			return fe, err
+		}
+		if name == "COUNT" {
+			return float64(len(numbers)), nil
+		}
+		if len(numbers) == 0 {
+			if name == "AVERAGE" {
+				return formulaError("#DIV/0!"), nil
+			}
+			return 0.0, nil
+		}
+		ret := numbers[0]
+		for _, n := range numbers[1:] {
+			switch name {
+			case "SUM", "AVERAGE":
+				ret += n
+			case "MIN":
+				ret = math.Min(ret, n)
+			case "MAX":
+				ret = math.Max(ret, n)
+			}
+		}
+		if name == "AVERAGE" {
+			ret /= float64(len(numbers))
+		}
+		return ret, nil
+	}
+
+	for i := range args {
+		v, err := p.value(args[i])
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+
+	switch name {
+	case "IF":
+		if len(args) < 2 || len(args) > 3 {
+			return nil, errUnsupportedFormula
+		}
+		cond, fe := toNumber(args[0])
+		if fe != nil {
+			return fe, nil
+		}
+		if cond != 0 {
+			return args[1], nil
+		} else if len(args) == 3 {
+			return args[2], nil
+		}
+		return false, nil
+	case "AND", "OR":
+		ret := name == "AND"
+		for _, a := range args {
+			n, fe := toNumber(a)
+			if fe != nil {
+				return fe, nil
+			}
+			if name == "AND" {
+				ret = ret && n != 0
+			} else {
+				ret = ret || n != 0
+			}
+		}
+		return ret, nil
+	case "CONCATENATE":
+		var b strings.Builder
+		for _, a := range args {
+			b.WriteString(formatFormulaValue(a))
+		}
+		return b.String(), nil
+	case "LEN":
+		if len(args) != 1 {
+			return nil, errUnsupportedFormula
+		}
+		return float64(len([]rune(formatFormulaValue(args[0])))), nil
+	}
+
+	numbers, fe, err := p.numbers(args)
+	if err != nil || fe != nil {
+		return fe, err
+	}
+	switch {
+	case name == "NOT" && len(numbers) == 1:
+		return numbers[0] == 0, nil
+	case name == "ABS" && len(numbers) == 1:
+		return math.Abs(numbers[0]), nil
+	case name == "INT" && len(numbers) == 1:
+		return math.Floor(numbers[0]), nil
+	case name == "SQRT" && len(numbers) == 1:
+		return math.Sqrt(numbers[0]), nil
+	case name == "POWER" && len(numbers) == 2:
+		return math.Pow(numbers[0], numbers[1]), nil
+	case name == "MOD" && len(numbers) == 2:
+		if numbers[1] == 0 {
+			return formulaError("#DIV/0!"), nil
+		}
+		return numbers[0] - numbers[1]*math.Floor(numbers[0]/numbers[1]), nil
+	case (name == "ROUND" || name == "ROUNDUP" || name == "ROUNDDOWN") && (len(numbers) == 1 || len(numbers) == 2):
+		digits := 0.0
+		if len(numbers) == 2 {
+			digits = numbers[1]
+		}
+		scale := math.Pow(10, digits)
+		n := numbers[0] * scale
+		switch name {
+		case "ROUND":
+			n = math.Round(n)
+		case "ROUNDUP":
+			if n < 0 {
+				n = math.Floor(n)
+			} else {
+				n = math.Ceil(n)
+			}
+		case "ROUNDDOWN":
+			n = math.Trunc(n)
+		}
+		return n / scale, nil
+	}
+	return nil, errUnsupportedFormula
+}
@@ -1,198 +1,1075 @@
-package main
-
-import (
-	"fmt"
-	"log"
-	"os"
-	"os/exec"
-	"strings"
-	"text/template"
-)
-
-func title(s string) string {
-	if len(s) > 0 && s[0] >= 'a' && s[0] <= 'z' {
-		b := []byte(s)
-		b[0] -= ('a' - 'A')
-		return string(b)
-	} else {
-		return s
-	}
-}
-
-func (p *ValueParser) GenGoStruct(s *strings.Builder, _ string) {
-}
-
-func (p *ValueParser) GetGoType() string {
-	switch p.valueType {
-	case typeInt:
-		return "int"
-	case typeString:
-		return "string"
-	case typeBool:
-		return "bool"
-	case typeFloat:
-		return "float64"
-	default:
-		panic("error")
-	}
-}
-
-func (p *ArrayParser) GenGoStruct(s *strings.Builder, s1 string) {
-	p.elements.GenGoStruct(s, s1)
-}
-
-func (p *ArrayParser) GetGoType() string {
-	return "[]" + p.elements.GetGoType()
-}
-
-func (p *StructParser) GetGoType() string {
-	return p.goType
-}
-
-func (p *StructParser) GenGoStruct(s *strings.Builder, s1 string) {
-	goStructType := title(s1)
-	p.goType = goStructType
-	//先遍历field生成所有嵌套类型
-	for _, v := range p.fieldsArray {
-		f := p.fields[v]
-		f.GenGoStruct(s, goStructType+title(v))
-	}
-
-	fmt.Fprintf(s, "type %s struct {\n", goStructType)
-	for _, v := range p.fieldsArray {
-		f := p.fields[v]
-		fmt.Fprintf(s, "\t%s %s `json:\"%s\"`\n", title(v), f.GetGoType(), v)
-	}
-	s.WriteString("}\n\n")
-}
-
-type goStruct struct {
-	TableName string
-	Data      string
-	Package   string
-	tmpl      *template.Template
-	str       strings.Builder
-}
-
-var goTemplate string = `
-package {{.Package}}
-
-import(
-	"encoding/json"
-	"io"
-	"os"
-	"sync/atomic"
-)
-
-{{.Data}}
-
-type _{{.TableName}}Map map[int]*{{.TableName}}
-
-var __{{.TableName}}Map atomic.Value
-
-func init() {
-	__{{.TableName}}Map.Store(make(_{{.TableName}}Map))
-}
-
-func get{{.TableName}}Map() _{{.TableName}}Map {
-	return __{{.TableName}}Map.Load().(_{{.TableName}}Map)
-}
-
-func set{{.TableName}}Map(m _{{.TableName}}Map) {
-	__{{.TableName}}Map.Store(m)
-}
-
-func Get{{.TableName}}(id int) (*{{.TableName}}, bool) {
-	m, ok := get{{.TableName}}Map()[id]
-	return m, ok
-}
-
-func load{{.TableName}}FromBytes(s []byte) error {
-	m := make(_{{.TableName}}Map)
-	err := json.Unmarshal(s, &m)
-	if err != nil {
-		return err
-	}
-	set{{.TableName}}Map(m)
-	return nil
-}
-
-func Load{{.TableName}}FromString(s string) error {
-	return load{{.TableName}}FromBytes([]byte(s))
-}
-
-func Load{{.TableName}}FromFile(path string) error {
-	jsonFile, err := os.Open(path)
-	if err != nil {
-		return err
-	}
-	defer jsonFile.Close()
-	jsonData, err := io.ReadAll(jsonFile)
-	if err != nil {
-		return err
-	}
-	return load{{.TableName}}FromBytes(jsonData)
-}
-
-func ForEach{{.TableName}}(fn func(m *{{.TableName}}) bool) {
-	for _, m := range get{{.TableName}}Map() {
-		if !fn(m) {
-			break
-		}
-	}
-}
-`
-
-func (j *goStruct) walkOk(writePath string) {
-	path := fmt.Sprintf("%s/%s", writePath, j.Package)
-	filename := fmt.Sprintf("%s/%s.go", path, j.TableName)
-	os.MkdirAll(path, os.ModePerm)
-	f, err := os.OpenFile(filename, os.O_RDWR, os.ModePerm)
-	if err != nil {
-		if os.IsNotExist(err) {
-			f, err = os.Create(filename)
-			if err != nil {
-				panic(err)
-			}
-		} else {
-			panic(err)
-		}
-	}
-
-	defer func() {
-		f.Close()
-		cmd := exec.Command("gofmt", "-w", filename)
-		err = cmd.Run()
-		if err != nil {
-			fmt.Println(err)
-		}
-	}()
-
-	err = os.Truncate(filename, 0)
-	if err != nil {
-		panic(err)
-	}
-
-	j.Data = j.str.String()
-	err = j.tmpl.Execute(f, j)
-	if err != nil {
-		panic(err)
-	} else {
-		log.Printf("%s Write ok\n", filename)
-	}
-}
-
-func (j *goStruct) outputGoJson(tmpl *template.Template, writePath string, colNames []string, types []string, rows [][]string, table *Table, idIndex int) {
-	j.TableName = table.name
-	j.tmpl = tmpl
-	fields := []string{}
-	for i := 0; i < len(colNames); i++ {
-		fields = append(fields, fmt.Sprintf("%s:%s", strings.Split(colNames[i], ":")[0], types[i]))
-	}
-	str := "{" + strings.Join(fields, ",") + "}"
-	p, err := MakeParser(str)
-	if err != nil {
-		panic(err)
-	}
-	p.GenGoStruct(&j.str, title(table.name))
-}
+package main
+
+import (
+	"fmt"
+	gotoken "go/token"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+func title(s string) string {
+	if len(s) > 0 && s[0] >= 'a' && s[0] <= 'z' {
+		b := []byte(s)
+		b[0] -= ('a' - 'A')
+		return string(b)
+	} else {
+		return s
+	}
+}
+
+func (p *ValueParser) GenGoStruct(s *strings.Builder, _ string) {
+}
+
+func (p *ValueParser) GetGoType() string {
+	switch p.valueType {
+	case typeInt:
+		return "int"
+	case typeString:
+		return "string"
+	case typeBool:
+		return "bool"
+	case typeFloat:
+		return "float64"
+	default:
+		panic("error")
+	}
+}
+
+func (p *ArrayParser) GenGoStruct(s *strings.Builder, s1 string) {
+	p.elements.GenGoStruct(s, s1)
+}
+
+func (p *ArrayParser) GetGoType() string {
+	return "[]" + p.elements.GetGoType()
+}
+
+func (p *StructParser) GetGoType() string {
+	return p.goType
+}
+
+func (p *StructParser) GenGoStruct(s *strings.Builder, s1 string) {
+	goStructType := title(s1)
+	p.goType = goStructType
+	//先遍历field生成所有嵌套类型
+	for _, v := range p.fieldsArray {
+		f := p.fields[v]
+		f.GenGoStruct(s, goStructType+title(v))
+	}
+
+	fmt.Fprintf(s, "type %s struct {\n", goStructType)
+	for _, v := range p.fieldsArray {
+		f := p.fields[v]
+		fmt.Fprintf(s, "\t%s %s `json:\"%s\"`\n", title(v), f.GetGoType(), v)
+	}
+	s.WriteString("}\n\n")
+}
+
+// 只读结构体的字段名,首字母小写,与关键字冲突时加_
+func goField(name string, readonly bool) string {
+	if !readonly {
+		return title(name)
+	}
+	b := []byte(name)
+	if len(b) > 0 && b[0] >= 'A' && b[0] <= 'Z' {
+		b[0] += ('a' - 'A')
+	}
+	if gotoken.IsKeyword(string(b)) {
+		b = append(b, '_')
+	}
+	return string(b)
+}
+
+// 返回p类型的值v的副本的表达式,多维数组逐层复制
+func goCopy(p Parser, v string, depth int) string {
+	a, ok := baseParser(p).(*ArrayParser)
+	if !ok {
+		return v
+	}
+	t := a.GetGoType()
+	if _, ok := baseParser(a.elements).(*ArrayParser); !ok {
+		return fmt.Sprintf("append(%s(nil), %s...)", t, v)
+	}
+	e := fmt.Sprintf("e%d", depth)
+	return fmt.Sprintf("func() %s {\nr := make(%s, len(%s))\nfor i, %s := range %s {\nr[i] = %s\n}\nreturn r\n}()", t, t, v, e, v, goCopy(a.elements, e, depth+1))
+}
+
+// 数组(包括多维数组)元素或p本身为结构体时返回该结构体
+func goElemStruct(p Parser) *StructParser {
+	switch pp := baseParser(p).(type) {
+	case *ArrayParser:
+		return goElemStruct(pp.elements)
+	case *StructParser:
+		return pp
+	default:
+		return nil
+	}
+}
+
+// 生成只读结构体:字段不导出,通过同名方法读取,数组返回副本,通过UnmarshalJSON解码json。
+// recv为方法的接收者类型,表的结构体使用指针,嵌套的结构体使用值
+func genGoReadonlyStruct(s *strings.Builder, p *StructParser, name string, recv string) {
+	goStructType := title(name)
+	p.goType = goStructType
+	for _, v := range p.fieldsArray {
+		if sp := goElemStruct(p.fields[v]); sp != nil {
+			genGoReadonlyStruct(s, sp, goStructType+title(v), goStructType+title(v))
+		}
+	}
+
+	fmt.Fprintf(s, "type %s struct {\n", goStructType)
+	for _, v := range p.fieldsArray {
+		fmt.Fprintf(s, "\t%s %s\n", goField(v, true), p.fields[v].GetGoType())
+	}
+	s.WriteString("}\n\n")
+	for _, v := range p.fieldsArray {
+		f := p.fields[v]
+		fmt.Fprintf(s, "func (m %s) %s() %s {\n\treturn %s\n}\n\n", recv, title(v), f.GetGoType(), goCopy(f, "m."+goField(v, true), 0))
+	}
+	fmt.Fprintf(s, "func (m *%s) UnmarshalJSON(b []byte) error {\n\tvar v struct {\n", goStructType)
+	for _, v := range p.fieldsArray {
+		fmt.Fprintf(s, "\t\t%s %s `json:\"%s\"`\n", title(v), p.fields[v].GetGoType(), v)
+	}
+	s.WriteString("\t}\n\tif err := json.Unmarshal(b, &v); err != nil {\n\t\treturn err\n\t}\n")
+	for _, v := range p.fieldsArray {
+		fmt.Fprintf(s, "\tm.%s = v.%s\n", goField(v, true), title(v))
+	}
+	s.WriteString("\treturn nil\n}\n\n")
+}
+
+// 一个表的go文件的模板数据
+type goStruct struct {
+	TableName string
+	Data      string
+	Package   string
+	IdType    string
+	IdField   string //id的字段名
+	Literal   string //表内容的go字面量,为空时运行时从json加载
+	Embed     bool   //通过go:embed嵌入json文件
+	Indexes   []*goIndex
+	Order     string //id:按id排序 row:按表中的行序
+	Binary    bool   //Get使用二分查找
+	Refs      []*goRef
+	Readonly  bool //只读结构体的UnmarshalJSON使用encoding/json
+	Consts    []*goConst
+}
+
+// 由const列属性生成的常量
+type goConst struct {
+	Name  string
+	Value string //id的go字面量
+}
+
+// 由ref列属性生成的跨表引用校验
+type goRef struct {
+	Field  string
+	Member string //结构体中的字段名
+	Table  string
+	Array  bool
+	Zero   string //空单元格对应的零值,不检查
+}
+
+// 由index或multiindex列属性生成的索引
+type goIndex struct {
+	Field  string
+	Member string //结构体中的字段名
+	Type   string
+	Multi  bool
+}
+
+// go模式每个表生成一个文件,最后生成tables.go加载所有表
+type goGenerator struct {
+	Package  string
+	Tables   []string
+	data     string //file:运行时读取json文件 literal:表内容直接生成为go代码 embed:嵌入json文件
+	order    string //id|row
+	binary   bool
+	readonly bool //字段不导出,生成读取字段的方法
+	runtime  bool //生成使用table包的代码
+	Watch    bool //生成Watch
+	mu       sync.Mutex
+}
+
+var goTemplate string = `
+package {{.Package}}
+
+import(
+{{- if .Embed}}
+	_ "embed"
+{{- end}}
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+{{.Data}}
+{{- if .Consts}}
+const (
+{{- range .Consts}}
+	{{.Name}} = {{.Value}}
+{{- end}}
+)
+{{end}}
+
+type _{{.TableName}}Map map[{{.IdType}}]*{{.TableName}}
+
+// 表数据和索引,加载时一起生成,作为快照的一部分整体替换
+type _{{.TableName}}Table struct {
+	m _{{.TableName}}Map
+	l []*{{.TableName}} //{{if eq .Order "row"}}按表中的行序排列{{else}}按id排序{{end}}
+{{- range .Indexes}}
+	by{{.Field}} map[{{.Type}}]{{if .Multi}}[]{{end}}*{{$.TableName}}
+{{- end}}
+	derived interface{} //hook返回的数据
+}
+
+func init() {
+	set{{.TableName}}List(nil)
+}
+{{if .Literal}}
+func init() {
+	l := []*{{.TableName}}{
+{{.Literal}}
+	}
+{{- if ne .Order "row"}}
+	sort{{.TableName}}List(l)
+{{- end}}
+	set{{.TableName}}List(l)
+}
+{{end}}
+func make{{.TableName}}Table(l []*{{.TableName}}) *_{{.TableName}}Table {
+	t := &_{{.TableName}}Table{m: make(_{{.TableName}}Map, len(l)), l: l}
+{{- range .Indexes}}
+	t.by{{.Field}} = map[{{.Type}}]{{if .Multi}}[]{{end}}*{{$.TableName}}{}
+{{- end}}
+	for _, v := range l {
+		t.m[v.{{.IdField}}] = v
+{{- range .Indexes}}
+{{- if .Multi}}
+		t.by{{.Field}}[v.{{.Member}}] = append(t.by{{.Field}}[v.{{.Member}}], v)
+{{- else}}
+		t.by{{.Field}}[v.{{.Member}}] = v
+{{- end}}
+{{- end}}
+	}
+	return t
+}
+
+func sort{{.TableName}}List(l []*{{.TableName}}) {
+	sort.Slice(l, func(i, j int) bool { return l[i].{{.IdField}} < l[j].{{.IdField}} })
+}
+
+// 只替换这一个表,其它表不变
+func set{{.TableName}}List(l []*{{.TableName}}) {
+	t := make{{.TableName}}Table(l)
+	updateSnapshot(func(s *Tables) {
+		s.t{{.TableName}} = t
+	})
+}
+
+func (s *Tables) Get{{.TableName}}(id {{.IdType}}) (*{{.TableName}}, bool) {
+{{- if .Binary}}
+	l := s.t{{.TableName}}.l
+	i := sort.Search(len(l), func(i int) bool { return l[i].{{.IdField}} >= id })
+	if i < len(l) && l[i].{{.IdField}} == id {
+		return l[i], true
+	}
+	return nil, false
+{{- else}}
+	m, ok := s.t{{.TableName}}.m[id]
+	return m, ok
+{{- end}}
+}
+
+func Get{{.TableName}}(id {{.IdType}}) (*{{.TableName}}, bool) {
+	return Snapshot().Get{{.TableName}}(id)
+}
+{{range .Indexes}}
+{{- if .Multi}}
+{{- if $.Readonly}}
+// 返回副本,修改返回的slice不影响表
+{{- else}}
+// 不要修改返回的slice
+{{- end}}
+func (s *Tables) Get{{$.TableName}}sBy{{.Field}}(v {{.Type}}) []*{{$.TableName}} {
+{{- if $.Readonly}}
+	return append([]*{{$.TableName}}(nil), s.t{{$.TableName}}.by{{.Field}}[v]...)
+{{- else}}
+	return s.t{{$.TableName}}.by{{.Field}}[v]
+{{- end}}
+}
+
+func Get{{$.TableName}}sBy{{.Field}}(v {{.Type}}) []*{{$.TableName}} {
+	return Snapshot().Get{{$.TableName}}sBy{{.Field}}(v)
+}
+{{else}}
+func (s *Tables) Get{{$.TableName}}By{{.Field}}(v {{.Type}}) (*{{$.TableName}}, bool) {
+	m, ok := s.t{{$.TableName}}.by{{.Field}}[v]
+	return m, ok
+}
+
+func Get{{$.TableName}}By{{.Field}}(v {{.Type}}) (*{{$.TableName}}, bool) {
+	return Snapshot().Get{{$.TableName}}By{{.Field}}(v)
+}
+{{end}}
+{{- end}}
+// 校验引用其它表的列,空值不检查
+func (s *Tables) validate{{.TableName}}() error {
+{{- if .Refs}}
+	for _, v := range s.t{{.TableName}}.l {
+{{- range .Refs}}
+{{- if .Array}}
+		for _, r := range v.{{.Member}} {
+			if _, ok := s.t{{.Table}}.m[r]; !ok {
+				return fmt.Errorf("{{$.TableName}} %v: {{.Field}} %v not found in {{.Table}}", v.{{$.IdField}}, r)
+			}
+		}
+{{- else}}
+		if _, ok := s.t{{.Table}}.m[v.{{.Member}}]; !ok && v.{{.Member}} != {{.Zero}} {
+			return fmt.Errorf("{{$.TableName}} %v: {{.Field}} %v not found in {{.Table}}", v.{{$.IdField}}, v.{{.Member}})
+		}
+{{- end}}
+{{- end}}
+	}
+{{- end}}
+	return nil
+}
+
+// 按json中的顺序解码
+func decode{{.TableName}}Table(s []byte) (*_{{.TableName}}Table, error) {
+	d := json.NewDecoder(bytes.NewReader(s))
+	if t, err := d.Token(); err != nil {
+		return nil, err
+	} else if t != json.Delim('{') {
+		return nil, errors.New("{{.TableName}}: invalid json")
+	}
+	var l []*{{.TableName}}
+	for d.More() {
+		if _, err := d.Token(); err != nil {
+			return nil, err
+		}
+		v := &{{.TableName}}{}
+		if err := d.Decode(v); err != nil {
+			return nil, err
+		}
+		l = append(l, v)
+	}
+{{- if ne .Order "row"}}
+	sort{{.TableName}}List(l)
+{{- end}}
+	t := make{{.TableName}}Table(l)
+	if h := __{{.TableName}}Hook; h != nil {
+		var err error
+		if t.derived, err = h(t.l); err != nil {
+			return nil, fmt.Errorf("{{.TableName}}: %w", err)
+		}
+	}
+	return t, nil
+}
+
+var __{{.TableName}}Hook func(l []*{{.TableName}}) (interface{}, error)
+
+// 设置加载{{.TableName}}时调用的hook,在表替换之前调用,返回错误时放弃这次加载,原来的数据不变。
+// 返回的数据与表一起保存在快照中,通过{{.TableName}}Derived取得,可以用来保存由表计算出的数据。
+// 应在加载之前设置,再次设置时替换之前的hook
+func Set{{.TableName}}Hook(fn func(l []*{{.TableName}}) (derived interface{}, err error)) {
+	__{{.TableName}}Hook = fn
+}
+
+func (s *Tables) {{.TableName}}Derived() interface{} {
+	return s.t{{.TableName}}.derived
+}
+
+func {{.TableName}}Derived() interface{} {
+	return Snapshot().{{.TableName}}Derived()
+}
+
+func read{{.TableName}}Table(path string) (*_{{.TableName}}Table, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decode{{.TableName}}Table(b)
+}
+
+// 只替换这一个表,不校验跨表引用
+func load{{.TableName}}FromBytes(s []byte) error {
+	t, err := decode{{.TableName}}Table(s)
+	if err != nil {
+		return err
+	}
+	updateSnapshot(func(s *Tables) {
+		s.t{{.TableName}} = t
+	})
+	return nil
+}
+
+func Load{{.TableName}}FromString(s string) error {
+	return load{{.TableName}}FromBytes([]byte(s))
+}
+
+func Load{{.TableName}}FromFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return load{{.TableName}}FromBytes(b)
+}
+{{if .Embed}}
+//go:embed {{.TableName}}.json
+var _{{.TableName}}JSON []byte
+
+// 加载嵌入的json,可以再用Load{{.TableName}}FromFile覆盖
+func Load{{.TableName}}Embedded() error {
+	return load{{.TableName}}FromBytes(_{{.TableName}}JSON)
+}
+{{end}}
+// 返回{{if eq .Order "row"}}按表中的行序{{else}}按id{{end}}排列的所有行,{{if .Readonly}}返回副本,修改返回的slice不影响表{{else}}不要修改返回的slice{{end}}
+func (s *Tables) All{{.TableName}}() []*{{.TableName}} {
+{{- if .Readonly}}
+	return append([]*{{.TableName}}(nil), s.t{{.TableName}}.l...)
+{{- else}}
+	return s.t{{.TableName}}.l
+{{- end}}
+}
+
+func All{{.TableName}}() []*{{.TableName}} {
+	return Snapshot().All{{.TableName}}()
+}
+
+func ForEach{{.TableName}}(fn func(m *{{.TableName}}) bool) {
+	for _, m := range Snapshot().t{{.TableName}}.l {
+		if !fn(m) {
+			break
+		}
+	}
+}
+`
+
+var goTablesTemplate string = `
+package {{.Package}}
+
+import (
+{{- if .Watch}}
+	"crypto/sha256"
+	"os"
+{{- end}}
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+{{- if .Watch}}
+	"time"
+{{- end}}
+)
+
+// 所有表的一致视图,加载时整体替换,不要修改其中的数据
+type Tables struct {
+{{- range .Tables}}
+	t{{.}} *_{{.}}Table
+{{- end}}
+}
+
+var __snapshot atomic.Value
+var __snapshotMu sync.Mutex
+
+// 返回当前所有表的快照,一次请求中使用同一个快照可以看到一致的数据
+func Snapshot() *Tables {
+	s, _ := __snapshot.Load().(*Tables)
+	return s
+}
+
+// 复制当前快照,修改后替换
+func updateSnapshot(fn func(s *Tables)) {
+	__snapshotMu.Lock()
+	defer __snapshotMu.Unlock()
+	s := &Tables{}
+	if old := Snapshot(); old != nil {
+		*s = *old
+	}
+	fn(s)
+	__snapshot.Store(s)
+}
+
+// 校验跨表引用
+func (s *Tables) validate() error {
+{{- range .Tables}}
+	if err := s.validate{{.}}(); err != nil {
+		return err
+	}
+{{- end}}
+	return nil
+}
+
+// 校验通过后替换所有表
+func commit(s *Tables) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	__snapshotMu.Lock()
+	defer __snapshotMu.Unlock()
+	__snapshot.Store(s)
+	return nil
+}
+
+// 从dir加载所有表到新的快照,文件名为表名.json,校验跨表引用后一次性替换所有表,出错时不替换任何表
+func LoadAll(dir string) error {
+	s := &Tables{}
+	var err error
+{{- range .Tables}}
+	if s.t{{.}}, err = read{{.}}Table(filepath.Join(dir, "{{.}}.json")); err != nil {
+		return err
+	}
+{{- end}}
+	return commit(s)
+}
+{{if .Embed}}
+// 加载所有嵌入的表,与LoadAll一样一次性替换
+func LoadEmbedded() error {
+	s := &Tables{}
+	var err error
+{{- range .Tables}}
+	if s.t{{.}}, err = decode{{.}}Table(_{{.}}JSON); err != nil {
+		return err
+	}
+{{- end}}
+	return commit(s)
+}
+{{end}}
+{{- if .Watch}}
+// Watch检查文件变化的间隔
+var WatchInterval = time.Second
+
+type __watchFile struct {
+	modTime time.Time
+	size    int64
+	sum     [sha256.Size]byte
+}
+
+// 解码变化的表,替换到当前快照的副本中,校验跨表引用后替换
+func reloadChanged(changed map[string][]byte) error {
+	__snapshotMu.Lock()
+	defer __snapshotMu.Unlock()
+	s := &Tables{}
+	if old := Snapshot(); old != nil {
+		*s = *old
+	}
+	var err error
+{{- range .Tables}}
+	if b, ok := changed["{{.}}"]; ok {
+		if s.t{{.}}, err = decode{{.}}Table(b); err != nil {
+			return err
+		}
+	}
+{{- end}}
+	if err = s.validate(); err != nil {
+		return err
+	}
+	__snapshot.Store(s)
+	return nil
+}
+
+// 返回内容发生变化的文件,只修改了时间的文件不算变化
+func pollChanged(dir string, files map[string]*__watchFile) (map[string][]byte, error) {
+	changed := map[string][]byte{}
+	polled := map[string]*__watchFile{}
+	for _, name := range []string{ {{- range .Tables}}"{{.}}", {{end -}} } {
+		path := filepath.Join(dir, name+".json")
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		old := files[name]
+		if old != nil && old.modTime.Equal(fi.ModTime()) && old.size == fi.Size() {
+			continue
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		f := &__watchFile{modTime: fi.ModTime(), size: fi.Size(), sum: sha256.Sum256(b)}
+		polled[name] = f
+		if old == nil || old.sum != f.sum {
+			changed[name] = b
+		}
+	}
+	//所有文件都读取成功后才更新files,否则下次重新检查
+	for name, f := range polled {
+		files[name] = f
+	}
+	return changed, nil
+}
+
+// 每隔WatchInterval检查dir中的json文件,重新加载内容变化的表。
+// 变化的表一起替换,解析或校验出错时保留原来的数据,出错的表在下次有文件变化时一起重新加载,
+// 如先修改Shop引用了不存在的Item出错,再修改Item后两个表一起替换。每次重新加载后调用onReload,成功时err为nil。
+// 应先用LoadAll加载dir,调用stop停止监视
+func Watch(dir string, onReload func(err error)) (stop func()) {
+	files := map[string]*__watchFile{}
+	pollChanged(dir, files)
+	//加载失败的表,下次有文件变化时重试
+	var pending map[string][]byte
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(WatchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			changed, err := pollChanged(dir, files)
+			if err == nil && len(changed) == 0 {
+				continue
+			}
+			if err == nil {
+				//上次失败的表和这次变化的表一起重新加载
+				for name, b := range pending {
+					if _, ok := changed[name]; !ok {
+						changed[name] = b
+					}
+				}
+				if err = reloadChanged(changed); err != nil {
+					pending = changed
+				} else {
+					pending = nil
+				}
+			}
+			if onReload != nil {
+				onReload(err)
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+{{- end}}
+`
+
+// -goruntime=true时生成的表的代码,加载,查找等由table包实现
+var goRuntimeTemplate string = `
+package {{.Package}}
+
+import (
+{{- if .Embed}}
+	_ "embed"
+{{- end}}
+{{- if .Readonly}}
+	"encoding/json"
+{{- end}}
+{{- if .Refs}}
+	"fmt"
+{{- end}}
+
+	"` + goRuntimeImport + `"
+)
+
+{{.Data}}
+{{- if .Consts}}
+const (
+{{- range .Consts}}
+	{{.Name}} = {{.Value}}
+{{- end}}
+)
+{{end}}
+
+var {{.TableName}}Table = table.New("{{.TableName}}", func(v *{{.TableName}}) {{.IdType}} { return v.{{.IdField}} }){{if eq .Order "row"}}.RowOrder(){{end}}{{if .Binary}}.BinarySearch(){{end}}{{if .Readonly}}.Readonly(){{end}}.Register(registry)
+{{range .Indexes}}
+var {{$.TableName}}{{if .Multi}}s{{end}}By{{.Field}} = table.New{{if .Multi}}Multi{{end}}Index({{$.TableName}}Table, func(v *{{$.TableName}}) {{.Type}} { return v.{{.Member}} })
+{{end}}
+{{- if .Literal}}
+func init() {
+	{{.TableName}}Table.Set([]*{{.TableName}}{
+{{.Literal}}
+	})
+}
+{{end}}
+{{- if .Embed}}
+//go:embed {{.TableName}}.json
+var _{{.TableName}}JSON []byte
+{{end}}
+// 校验快照s中引用其它表的列,空值不检查
+func validate{{.TableName}}(s *table.Snapshot) error {
+{{- if .Refs}}
+	for _, v := range {{.TableName}}Table.In(s).All() {
+{{- range .Refs}}
+{{- if .Array}}
+		for _, ref := range v.{{.Member}} {
+			if _, ok := {{.Table}}Table.In(s).Get(ref); !ok {
+				return fmt.Errorf("{{$.TableName}} %v: {{.Field}} %v not found in {{.Table}}", v.{{$.IdField}}, ref)
+			}
+		}
+{{- else}}
+		if _, ok := {{.Table}}Table.In(s).Get(v.{{.Member}}); !ok && v.{{.Member}} != {{.Zero}} {
+			return fmt.Errorf("{{$.TableName}} %v: {{.Field}} %v not found in {{.Table}}", v.{{$.IdField}}, v.{{.Member}})
+		}
+{{- end}}
+{{- end}}
+	}
+{{- end}}
+	return nil
+}
+`
+
+var goRuntimeTablesTemplate string = `
+package {{.Package}}
+
+import (
+	"path/filepath"
+{{- if .Watch}}
+	"time"
+{{- end}}
+
+	"` + goRuntimeImport + `"
+)
+
+// 所有表保存在一个快照中,加载时一起原子替换
+var registry = table.NewRegistry()
+
+// 返回当前快照,一次请求中通过表名Table.In(s)和索引的GetIn(s, v)读取同一个快照,看到的所有表是一致的
+func Snapshot() *table.Snapshot {
+	return registry.Snapshot()
+}
+
+// 校验快照s中的跨表引用
+func validate(s *table.Snapshot) error {
+{{- range .Tables}}
+	if err := validate{{.}}(s); err != nil {
+		return err
+	}
+{{- end}}
+	return nil
+}
+
+// 从dir加载所有表,文件名为表名.json,校验跨表引用后一次性替换所有表,出错时不替换任何表
+func LoadAll(dir string) error {
+	return registry.Update(func(s *table.Snapshot) error {
+{{- range .Tables}}
+		r{{.}}, err := {{.}}Table.DecodeFile(filepath.Join(dir, "{{.}}.json"))
+		if err != nil {
+			return err
+		}
+		{{.}}Table.Put(s, r{{.}})
+{{- end}}
+		return validate(s)
+	})
+}
+{{if .Embed}}
+// 加载所有嵌入的表,与LoadAll一样一次性替换
+func LoadEmbedded() error {
+	return registry.Update(func(s *table.Snapshot) error {
+{{- range .Tables}}
+		r{{.}}, err := {{.}}Table.Decode(_{{.}}JSON)
+		if err != nil {
+			return err
+		}
+		{{.}}Table.Put(s, r{{.}})
+{{- end}}
+		return validate(s)
+	})
+}
+{{end}}
+{{- if .Watch}}
+// Watch检查文件变化的间隔
+var WatchInterval = time.Second
+
+// 解码变化的表,与其它表的当前数据一起校验后一次性替换
+func reloadChanged(changed map[string][]byte) error {
+	return registry.Update(func(s *table.Snapshot) error {
+{{- range .Tables}}
+		if b, ok := changed["{{.}}"]; ok {
+			r, err := {{.}}Table.Decode(b)
+			if err != nil {
+				return err
+			}
+			{{.}}Table.Put(s, r)
+		}
+{{- end}}
+		return validate(s)
+	})
+}
+
+// 每隔WatchInterval检查dir中的json文件,重新加载内容变化的表,出错时保留原来的数据。
+// 每次重新加载后调用onReload,成功时err为nil。应先用LoadAll加载dir,调用stop停止监视
+func Watch(dir string, onReload func(err error)) (stop func()) {
+	return table.Watch(dir, []string{ {{- range .Tables}}"{{.}}", {{end -}} }, WatchInterval, reloadChanged, onReload)
+}
+{{- end}}
+`
+
+const goRuntimeImport = "github.com/sniperHW/tabgo/table"
+
+func (g *goGenerator) Embed() bool {
+	return g.data == "embed"
+}
+
+// 执行模板写入go文件并格式化
+func writeGoFile(tmpl *template.Template, filename string, data interface{}) {
+	os.MkdirAll(filepath.Dir(filename), os.ModePerm)
+	f, err := os.Create(filename)
+	if err != nil {
+		panic(err)
+	}
+	err = tmpl.Execute(f, data)
+	f.Close()
+	if err != nil {
+		panic(err)
+	}
+	if err = exec.Command("gofmt", "-w", filename).Run(); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func (g *goGenerator) walkOk(writePath string) {
+	sort.Strings(g.Tables)
+	text := goTablesTemplate
+	if g.runtime {
+		text = goRuntimeTablesTemplate
+	}
+	tmpl, err := template.New("tables").Parse(text)
+	if err != nil {
+		panic(err)
+	}
+	filename := fmt.Sprintf("%s/%s/tables.go", writePath, g.Package)
+	writeGoFile(tmpl, filename, g)
+	log.Printf("%s Write ok\n", filename)
+	//-locale指定多个语言时每个语言walk一次,下一次重新收集
+	g.Tables = nil
+}
+
+// 生成的包中所有包级标识符(不包括常量),用于检查const列生成的常量名是否冲突
+func (g *goGenerator) names(tables []*Table) map[string]bool {
+	names := map[string]bool{}
+	add := func(l ...string) {
+		for _, v := range l {
+			names[v] = true
+		}
+	}
+	add("Snapshot", "LoadAll")
+	if g.runtime {
+		add("registry", "validate")
+	} else {
+		add("Tables", "__snapshot", "__snapshotMu", "updateSnapshot", "commit")
+	}
+	if g.Embed() {
+		add("LoadEmbedded")
+	}
+	if g.Watch {
+		add("WatchInterval", "reloadChanged", "Watch")
+		if !g.runtime {
+			add("__watchFile", "pollChanged")
+		}
+	}
+	//嵌套的结构体类型名为外层类型名+字段名
+	var structs func(p *StructParser, name string)
+	structs = func(p *StructParser, name string) {
+		add(name)
+		for _, v := range p.fieldsArray {
+			if sp := goElemStruct(p.fields[v]); sp != nil {
+				structs(sp, name+title(v))
+			}
+		}
+	}
+	for _, t := range tables {
+		p := &StructParser{fields: map[string]Parser{}}
+		for _, v := range t.fields {
+			if v.parser != nil {
+				p.fields[v.name] = v.parser
+				p.fieldsArray = append(p.fieldsArray, v.name)
+			}
+		}
+		structs(p, title(t.name))
+		n := t.name
+		if g.runtime {
+			add(n+"Table", "validate"+n)
+		} else {
+			add("_"+n+"Map", "_"+n+"Table", "make"+n+"Table", "sort"+n+"List", "set"+n+"List", "Get"+n,
+				"decode"+n+"Table", "__"+n+"Hook", "Set"+n+"Hook", n+"Derived", "read"+n+"Table",
+				"load"+n+"FromBytes", "Load"+n+"FromString", "Load"+n+"FromFile", "All"+n, "ForEach"+n)
+			if g.Embed() {
+				add("Load" + n + "Embedded")
+			}
+		}
+		if g.Embed() {
+			add("_" + n + "JSON")
+		}
+		for _, field := range t.fields {
+			if field.parser == nil {
+				continue
+			}
+			_, index := field.attr(indexAttr)
+			_, multi := field.attr(multiIndexAttr)
+			if !index && !multi {
+				continue
+			}
+			s := ""
+			if multi {
+				s = "s"
+			}
+			if g.runtime {
+				add(n + s + "By" + title(field.name))
+			} else {
+				add("Get" + n + s + "By" + title(field.name))
+			}
+		}
+	}
+	return names
+}
+
+// 生成表的结构体定义和加载函数,返回写入的文件名
+func (g *goGenerator) outputGo(tmpl *template.Template, writePath string, table *Table) string {
+	p := &StructParser{fields: map[string]Parser{}}
+	for _, v := range table.fields {
+		if v.parser != nil {
+			p.fields[v.name] = v.parser
+			p.fieldsArray = append(p.fieldsArray, v.name)
+		}
+	}
+	var str strings.Builder
+	if g.readonly {
+		genGoReadonlyStruct(&str, p, table.name, "*"+title(table.name))
+	} else {
+		p.GenGoStruct(&str, title(table.name))
+	}
+
+	g.mu.Lock()
+	g.Tables = append(g.Tables, table.name)
+	g.mu.Unlock()
+
+	data := &goStruct{
+		TableName: table.name,
+		Data:      str.String(),
+		Package:   g.Package,
+		IdType:    table.fields[table.idIndex].parser.GetGoType(),
+		IdField:   goField(table.fields[table.idIndex].name, g.readonly),
+		Embed:     g.Embed(),
+		Order:     g.order,
+		Binary:    g.binary,
+		Readonly:  g.readonly,
+	}
+	for _, field := range table.fields {
+		if field.parser == nil {
+			continue
+		}
+		_, index := field.attr(indexAttr)
+		_, multi := field.attr(multiIndexAttr)
+		if index || multi {
+			data.Indexes = append(data.Indexes, &goIndex{
+				Field:  title(field.name),
+				Member: goField(field.name, g.readonly),
+				Type:   field.parser.GetGoType(),
+				Multi:  multi,
+			})
+		}
+		if ref, ok := field.attr(refAttr); ok {
+			r := &goRef{
+				Field:  title(field.name),
+				Member: goField(field.name, g.readonly),
+				Table:  ref,
+				Array:  baseParser(field.parser).ValueType() == typeArray,
+			}
+			switch refElement(field.parser).ValueType() {
+			case typeString:
+				r.Zero = `""`
+			case typeBool:
+				r.Zero = "false"
+			default:
+				r.Zero = "0"
+			}
+			data.Refs = append(data.Refs, r)
+		}
+	}
+	for i, field := range table.fields {
+		if _, ok := field.attr(constAttr); !ok || field.parser == nil {
+			continue
+		}
+		for _, row := range table.rows {
+			if v := row.values[i]; v != nil && v.value.(string) != "" {
+				var lit strings.Builder
+				goLiteral(&lit, table.fields[table.idIndex].parser, row.values[table.idIndex], g.readonly)
+				data.Consts = append(data.Consts, &goConst{Name: constName(table.name, v.value.(string)), Value: lit.String()})
+			}
+		}
+	}
+	if g.Embed() {
+		//json文件与生成的代码放在一起
+		jsonTmpl, err := template.New("json").Parse(jsonTemplate)
+		if err != nil {
+			panic(err)
+		}
+		outputJson(jsonTmpl, fmt.Sprintf("%s/%s", writePath, g.Package), table)
+	}
+	if g.data == "literal" {
+		var lit strings.Builder
+		for _, row := range table.rows {
+			fmt.Fprintf(&lit, "\t\t&%s{", title(table.name))
+			cc := 0
+			for i, field := range table.fields {
+				if v := row.values[i]; v != nil && field.parser != nil {
+					if cc > 0 {
+						lit.WriteString(", ")
+					}
+					fmt.Fprintf(&lit, "%s: ", goField(field.name, g.readonly))
+					goLiteral(&lit, field.parser, v, g.readonly)
+					cc++
+				}
+			}
+			lit.WriteString("},\n")
+		}
+		data.Literal = lit.String()
+	}
+
+	filename := fmt.Sprintf("%s/%s/%s.go", writePath, g.Package, table.name)
+	writeGoFile(tmpl, filename, data)
+	return filename
+}
+
+// 输出v的go字面量,结构体类型需要先调用GenGoStruct生成
+func goLiteral(s *strings.Builder, p Parser, v *Value, readonly bool) {
+	switch pp := baseParser(p).(type) {
+	case *ArrayParser:
+		s.WriteString(pp.GetGoType() + "{")
+		for i, vv := range v.value.(*Array).value {
+			if i > 0 {
+				s.WriteString(", ")
+			}
+			goLiteral(s, pp.elements, vv, readonly)
+		}
+		s.WriteString("}")
+	case *StructParser:
+		s.WriteString(pp.GetGoType() + "{")
+		cc := 0
+		for _, f := range v.value.(*Struct).fields {
+			if f.value == nil {
+				continue
+			}
+			if cc > 0 {
+				s.WriteString(", ")
+			}
+			fmt.Fprintf(s, "%s: ", goField(f.name, readonly))
+			goLiteral(s, pp.fields[f.name], f.value, readonly)
+			cc++
+		}
+		s.WriteString("}")
+	default:
+		switch v.valueType {
+		case typeString:
+			s.WriteString(strconv.Quote(v.value.(string)))
+		case typeFloat:
+			s.WriteString(strconv.FormatFloat(v.value.(float64), 'g', -1, 64))
+		default:
+			fmt.Fprint(s, v.value)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/csv"
+	stdjson "encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// i18n类型的单元格在输出时被替换成key(表名.id.列名[.成员]),
+// 原文收集到每种语言的翻译文件中,已有的翻译会被保留
+type I18n struct {
+	langs   []string //langs[0]为表格中填写的语言
+	dir     string   //翻译文件所在目录
+	format  string   //翻译文件格式 json|csv
+	mode    string
+	pkg     string
+	source  map[string]string
+	entries map[string]map[string]*i18nEntry //lang -> key -> entry
+}
+
+type i18nEntry struct {
+	Source string `json:"source"`
+	Text   string `json:"text"`
+}
+
+func isI18n(p Parser) bool {
+	v, ok := baseParser(p).(*ValueParser)
+	return ok && v.i18n
+}
+
+// 把v中所有i18n字符串替换成key
+func (n *I18n) localize(p Parser, v *Value, key string) {
+	if v == nil {
+		return
+	}
+	switch pp := baseParser(p).(type) {
+	case *ValueParser:
+		if pp.i18n && v.value.(string) != "" {
+			n.source[key] = v.value.(string)
+			v.value = key
+		}
+	case *ArrayParser:
+		for i, vv := range v.value.(*Array).value {
+			n.localize(pp.elements, vv, key+"."+strconv.Itoa(i))
+		}
+	case *StructParser:
+		for _, f := range v.value.(*Struct).fields {
+			n.localize(pp.fields[f.name], f.value, key+"."+f.name)
+		}
+	}
+}
+
+func (n *I18n) collect(tables []*Table) {
+	n.source = map[string]string{}
+	for _, t := range tables {
+		for i, field := range t.fields {
+			if field.parser == nil || !hasI18n(field.parser) {
+				continue
+			}
+			for _, r := range t.rows {
+				n.localize(field.parser, r.values[i], fmt.Sprintf("%s.%s.%s", t.name, trim(r.id), field.name))
+			}
+		}
+	}
+}
+
+// p中是否包含i18n类型
+func hasI18n(p Parser) bool {
+	switch pp := baseParser(p).(type) {
+	case *ArrayParser:
+		return hasI18n(pp.elements)
+	case *StructParser:
+		for _, v := range pp.fields {
+			if hasI18n(v) {
+				return true
+			}
+		}
+		return false
+	default:
+		return isI18n(pp)
+	}
+}
+
+func (n *I18n) filename(lang string) string {
+	return filepath.Join(n.dir, lang+"."+n.format)
+}
+
+func (n *I18n) read(lang string) (map[string]*i18nEntry, error) {
+	entries := map[string]*i18nEntry{}
+	f, err := os.Open(n.filename(lang))
+	if os.IsNotExist(err) {
+		return entries, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if n.format == "csv" {
+		records, err := csv.NewReader(f).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		for i, v := range records {
+			if i > 0 && len(v) == 3 {
+				entries[v[0]] = &i18nEntry{Source: v[1], Text: v[2]}
+			}
+		}
+	} else if err = stdjson.NewDecoder(f).Decode(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (n *I18n) write(lang string, entries map[string]*i18nEntry) error {
+	os.MkdirAll(n.dir, os.ModePerm)
+	f, err := os.Create(n.filename(lang))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if n.format == "csv" {
+		var keys []string
+		for k := range entries {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		w := csv.NewWriter(f)
+		w.Write([]string{"key", "source", "text"})
+		for _, k := range keys {
+			w.Write([]string{k, entries[k].Source, entries[k].Text})
+		}
+		w.Flush()
+		return w.Error()
+	}
+	b, err := stdjson.MarshalIndent(entries, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	return err
+}
+
+// 把收集到的原文与已有翻译合并,check为true时不写文件
+func (n *I18n) merge(report *Reporter, check bool) {
+	n.entries = map[string]map[string]*i18nEntry{}
+	var keys []string
+	for k := range n.source {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, lang := range n.langs {
+		file := n.filename(lang)
+		old, err := n.read(lang)
+		if err != nil {
+			report.Errorf(filePos(file), "read translations err:%v", err)
+			continue
+		}
+
+		entries := map[string]*i18nEntry{}
+		missing := 0
+		for _, k := range keys {
+			source := n.source[k]
+			e := &i18nEntry{Source: source}
+			if i == 0 {
+				e.Text = source
+			} else if o, ok := old[k]; ok {
+				e.Text = o.Text
+				if o.Source != source && o.Text != "" {
+					report.Warnf(filePos(file), "source changed, translation may be stale:%s", k)
+				}
+			}
+			if e.Text == "" {
+				missing++
+			}
+			entries[k] = e
+		}
+		if missing > 0 {
+			report.Warnf(filePos(file), "%d missing translations", missing)
+		}
+		n.entries[lang] = entries
+
+		if !check {
+			if err = n.write(lang, entries); err != nil {
+				report.Errorf(filePos(file), "write translations err:%v", err)
+			}
+		}
+	}
+}
+
+// 输出运行时使用的翻译,没有翻译的使用原文
+func (n *I18n) texts(lang string) map[string]string {
+	texts := map[string]string{}
+	for k, e := range n.entries[lang] {
+		if e.Text != "" {
+			texts[k] = e.Text
+		} else {
+			texts[k] = e.Source
+		}
+	}
+	return texts
+}
+
+var i18nLuaTemplate string = `
+local i18n = {texts = {}}
+
+function i18n.load(lang)
+	i18n.texts = require("i18n_" .. lang)
+end
+
+function i18n.text(key)
+	return i18n.texts[key] or key
+end
+
+return i18n
+`
+
+var i18nGoTemplate string = `package %s
+
+import (
+	"encoding/json"
+	"os"
+	"sync/atomic"
+)
+
+var __i18n atomic.Value
+
+// 加载打表时生成的i18n_lang.json
+func LoadI18nFromFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	m := map[string]string{}
+	if err = json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	__i18n.Store(m)
+	return nil
+}
+
+// 返回key对应的文本,没有加载或找不到时返回key
+func I18n(key string) string {
+	if m, ok := __i18n.Load().(map[string]string); ok {
+		if s, ok := m[key]; ok {
+			return s
+		}
+	}
+	return key
+}
+`
+
+func writeFile(filename string, b []byte) {
+	os.MkdirAll(filepath.Dir(filename), os.ModePerm)
+	if err := os.WriteFile(filename, b, os.ModePerm); err != nil {
+		panic(err)
+	} else {
+		log.Printf("%s Write ok\n", filename)
+	}
+}
+
+// 每种语言输出一个key到文本的i18n_lang.json
+func (n *I18n) outputJson(dir string) {
+	for _, lang := range n.langs {
+		b, err := stdjson.MarshalIndent(n.texts(lang), "", "\t")
+		if err != nil {
+			panic(err)
+		}
+		writeFile(fmt.Sprintf("%s/i18n_%s.json", dir, lang), b)
+	}
+}
+
+func (n *I18n) output(writePath string) {
+	switch n.mode {
+	case "json":
+		n.outputJson(writePath)
+	case "lua":
+		for _, lang := range n.langs {
+			texts := n.texts(lang)
+			var keys []string
+			for k := range texts {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			b := []byte("return {\n")
+			for _, k := range keys {
+				b = append(b, fmt.Sprintf("\t[%q]=%q,\n", k, texts[k])...)
+			}
+			b = append(b, "}\n"...)
+			writeFile(fmt.Sprintf("%s/i18n_%s.lua", writePath, lang), b)
+		}
+		writeFile(fmt.Sprintf("%s/i18n.lua", writePath), []byte(i18nLuaTemplate))
+	case "go":
+		//LoadI18nFromFile读取与i18n.go一起生成的i18n_lang.json
+		n.outputJson(fmt.Sprintf("%s/%s", writePath, n.pkg))
+		writeFile(fmt.Sprintf("%s/%s/i18n.go", writePath, n.pkg), []byte(fmt.Sprintf(i18nGoTemplate, n.pkg)))
+	}
+}
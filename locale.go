@@ -0,0 +1,130 @@
+package main
+
+import (
+	"strings"
+)
+
+// 多语言列,如name@zh,name@en,name@ja
+type localeColumn struct {
+	locale string
+	index  int //在excel中的列下标
+	parser Parser
+}
+
+// 分离列名和语言
+func splitLocale(name string) (string, string) {
+	if i := strings.LastIndex(name, "@"); i > 0 && i < len(name)-1 {
+		return name[:i], name[i+1:]
+	}
+	return name, ""
+}
+
+// 把同名的多语言列合并成一列,合并后的列放在第一个语言所在的位置。
+// 没有指定locale时值为以语言为成员的结构体,否则只输出指定语言的值
+func (w *Walker) foldLocales(table *Table) bool {
+	ok := true
+	groups := map[string]*Column{}
+	for i, field := range table.fields {
+		if field.parser == nil {
+			continue
+		}
+		base, locale := splitLocale(field.name)
+		if locale == "" {
+			continue
+		}
+		if len(field.attrs) > 0 {
+			//合并后的列是多个列的值,index,ref,const等属性无法作用于它
+			w.report.Errorf(cellPos(table.file, NamesRow+1, i), "column %s: attributes can not be used with locale columns", field.name)
+			ok = false
+		}
+		c := &localeColumn{
+			locale: locale,
+			index:  i,
+			parser: field.parser,
+		}
+		if g, exist := groups[base]; exist {
+			g.locales = append(g.locales, c)
+			table.fields[i] = &Column{name: field.name}
+		} else {
+			groups[base] = &Column{
+				name:    base,
+				typeStr: field.typeStr,
+				tags:    field.tags,
+				locales: []*localeColumn{c},
+			}
+			table.fields[i] = groups[base]
+		}
+	}
+
+	for _, g := range groups {
+		if w.locale == "" {
+			p := &StructParser{fields: map[string]Parser{}}
+			for _, v := range g.locales {
+				p.fields[v.locale] = v.parser
+				p.fieldsArray = append(p.fieldsArray, v.locale)
+			}
+			g.parser = p
+		} else {
+			for _, v := range g.locales {
+				if v.locale == w.locale {
+					g.parser = v.parser
+				}
+			}
+			if g.parser == nil {
+				w.report.Errorf(filePos(table.file), "column %s has no locale %s", g.name, w.locale)
+				ok = false
+			}
+		}
+	}
+	return ok
+}
+
+// 解析多语言列,某些语言有值而其它语言为空时报告缺少翻译
+func (w *Walker) parseLocales(table *Table, field *Column, line int, row []string) (*Value, bool) {
+	values := make([]*Value, len(field.locales))
+	filled := false
+	ok := true
+	for i, v := range field.locales {
+		if isEmptyValue(row[v.index]) {
+			continue
+		}
+		filled = true
+		var err error
+		if values[i], err = v.parser.Parse(row[v.index]); err != nil {
+			w.report.Errorf(cellPos(table.file, line, v.index), "parse err:(%v) columm:(%s@%s) types:(%s) str:(%s)", err, field.name, v.locale, field.typeStr, row[v.index])
+			ok = false
+		}
+	}
+
+	parsed := ok
+	for i, v := range field.locales {
+		if values[i] != nil || !isEmptyValue(row[v.index]) {
+			continue
+		}
+		if filled && parsed {
+			w.report.Warnf(cellPos(table.file, line, v.index), "missing translation:%s", v.locale)
+		}
+		//空单元格也要检查!约束
+		var err error
+		if values[i], err = v.parser.Parse(row[v.index]); err != nil {
+			w.report.Errorf(cellPos(table.file, line, v.index), "parse err:(%v) columm:(%s@%s) types:(%s) str:(%s)", err, field.name, v.locale, field.typeStr, row[v.index])
+			ok = false
+		}
+	}
+
+	if !ok {
+		return nil, false
+	} else if w.locale != "" {
+		for i, v := range field.locales {
+			if v.locale == w.locale {
+				return values[i], true
+			}
+		}
+	}
+
+	st := &Struct{}
+	for i, v := range field.locales {
+		st.fields = append(st.fields, &Field{name: v.locale, value: values[i]})
+	}
+	return &Value{valueType: typeStruct, value: st}, true
+}
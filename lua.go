@@ -1,124 +1,120 @@
-package main
-
-import (
-	"fmt"
-	"log"
-	"os"
-	"strings"
-	"text/template"
-)
-
-func (a *Array) ToLuaString(s *strings.Builder) {
-	s.WriteString("{")
-	for i, vv := range a.value {
-		vv.ToLuaString(s)
-		if i != len(a.value)-1 {
-			s.WriteString(",")
-		}
-	}
-	s.WriteString("}")
-}
-
-func (f *Field) ToLuaString(s *strings.Builder) {
-	s.WriteString(f.name + "=")
-	f.value.ToLuaString(s)
-}
-
-func (ss *Struct) ToLuaString(s *strings.Builder) {
-	s.WriteString("{")
-	for i, vv := range ss.fields {
-		vv.ToLuaString(s)
-		if i != len(ss.fields)-1 {
-			s.WriteString(",")
-		}
-	}
-	s.WriteString("}")
-}
-
-func (v *Value) ToLuaString(s *strings.Builder) {
-	switch v.valueType {
-	case typeArray:
-		v.value.(*Array).ToLuaString(s)
-	case typeStruct:
-		v.value.(*Struct).ToLuaString(s)
-	case typeString:
-		fmt.Fprintf(s, "\"%v\"", v.value)
-	default:
-		fmt.Fprintf(s, "%v", v.value)
-	}
-}
-
-type lua struct {
-	TableName string
-	Data      string
-}
-
-var luaTemplate string = `
-local {{.TableName}} = {
-{{.Data}}	
-}
-
-return {{.TableName}}
-`
-
-func outputLua(tmpl *template.Template, writePath string, colNames []string, types []string, rows [][]string, table *Table, idIndex int) {
-	var builder strings.Builder
-	rr := 0
-	for rowNum, row := range rows {
-		if row[idIndex] != "" {
-			if rr > 0 {
-				builder.WriteString(",\n")
-			}
-			fmt.Fprintf(&builder, "\t[%v]={", row[0])
-			cc := 0
-			for i, field := range table.fields {
-				if field.parser != nil {
-					if v, err := field.parser.Parse(row[i]); err != nil {
-						panic(fmt.Errorf("parse err:(%v) table:(%s) columm:(%s) types:(%s) row:(%d) str:(%s)", err, table.name, colNames[i], types[i], rowNum+DatasRow+1, row[i]))
-					} else {
-						if v.valueType == typeStruct && len(v.value.(*Struct).fields) == 0 {
-							continue
-						}
-						if cc > 0 {
-							builder.WriteString(",")
-						}
-						fmt.Fprintf(&builder, "%s=", field.name)
-						v.ToLuaString(&builder)
-						cc++
-
-					}
-				}
-			}
-
-			builder.WriteString("}")
-			rr++
-		}
-	}
-
-	filename := fmt.Sprintf("%s/%s.lua", writePath, table.name)
-	os.MkdirAll(writePath, os.ModePerm)
-	f, err := os.OpenFile(filename, os.O_RDWR, os.ModePerm)
-	if err != nil {
-		if os.IsNotExist(err) {
-			f, err = os.Create(filename)
-			if err != nil {
-				panic(err)
-			}
-		} else {
-			panic(err)
-		}
-	}
-	defer f.Close()
-
-	err = os.Truncate(filename, 0)
-	if err != nil {
-		panic(err)
-	}
-
-	err = tmpl.Execute(f, lua{table.name, builder.String()})
-	if err != nil {
-		panic(err)
-	} else {
-		log.Printf("%s Write ok\n", filename)
-	}
-}
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+func (a *Array) ToLuaString(s *strings.Builder) {
+	s.WriteString("{")
+	for i, vv := range a.value {
+		vv.ToLuaString(s)
+		if i != len(a.value)-1 {
+			s.WriteString(",")
+		}
+	}
+	s.WriteString("}")
+}
+
+func (f *Field) ToLuaString(s *strings.Builder) {
+	s.WriteString(f.name + "=")
+	f.value.ToLuaString(s)
+}
+
+func (ss *Struct) ToLuaString(s *strings.Builder) {
+	s.WriteString("{")
+	for i, vv := range ss.fields {
+		vv.ToLuaString(s)
+		if i != len(ss.fields)-1 {
+			s.WriteString(",")
+		}
+	}
+	s.WriteString("}")
+}
+
+func (v *Value) ToLuaString(s *strings.Builder) {
+	switch v.valueType {
+	case typeArray:
+		v.value.(*Array).ToLuaString(s)
+	case typeStruct:
+		v.value.(*Struct).ToLuaString(s)
+	case typeString:
+		fmt.Fprintf(s, "\"%v\"", v.value)
+	default:
+		fmt.Fprintf(s, "%v", v.value)
+	}
+}
+
+type lua struct {
+	TableName string
+	Data      string
+}
+
+var luaTemplate string = `
+local {{.TableName}} = {
+{{.Data}}	
+}
+
+return {{.TableName}}
+`
+
+// 输出一行,不包含行之间的分隔符
+func luaRow(builder *strings.Builder, table *Table, row *Row) {
+	fmt.Fprintf(builder, "\t[%v]={", row.id)
+	cc := 0
+	for i, field := range table.fields {
+		v := row.values[i]
+		if v == nil {
+			continue
+		}
+		if v.valueType == typeStruct && len(v.value.(*Struct).fields) == 0 {
+			continue
+		}
+		if cc > 0 {
+			builder.WriteString(",")
+		}
+		fmt.Fprintf(builder, "%s=", field.name)
+		v.ToLuaString(builder)
+		cc++
+	}
+	builder.WriteString("}")
+}
+
+// 返回写入的文件名
+func outputLua(tmpl *template.Template, writePath string, table *Table) string {
+	var builder strings.Builder
+	for rr, row := range table.rows {
+		if rr > 0 {
+			builder.WriteString(",\n")
+		}
+		luaRow(&builder, table, row)
+	}
+
+	filename := fmt.Sprintf("%s/%s.lua", writePath, table.name)
+	os.MkdirAll(writePath, os.ModePerm)
+	f, err := os.OpenFile(filename, os.O_RDWR, os.ModePerm)
+	if err != nil {
+		if os.IsNotExist(err) {
+			f, err = os.Create(filename)
+			if err != nil {
+				panic(err)
+			}
+		} else {
+			panic(err)
+		}
+	}
+	defer f.Close()
+
+	err = os.Truncate(filename, 0)
+	if err != nil {
+		panic(err)
+	}
+
+	err = tmpl.Execute(f, lua{table.name, builder.String()})
+	if err != nil {
+		panic(err)
+	}
+	return filename
+}
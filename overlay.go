@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const OverlaysDir = "overlays" //覆盖表所在目录
+
+// 覆盖表只包含id和需要修改的列,表头格式与基础表相同(类型行可以不填,使用基础表的类型)。
+// 空单元格保留基础表的值。
+func (w *Walker) applyOverlay(table *Table, filePath string) {
+	file := filepath.Join(OverlaysDir, w.overlay, filepath.Base(filePath))
+	rows, err := w.readSheet(filePath, file)
+	if err != nil {
+		w.report.Errorf(filePos(file), "OpenFileError:%v", err)
+		return
+	}
+	if len(rows) <= DatasRow {
+		return
+	}
+
+	//覆盖表的列下标到基础表列下标的映射,-1表示忽略
+	names := rows[NamesRow]
+	columns := make([]int, len(names))
+	idIndex := -1
+	for i, v := range names {
+		name, _ := splitColumnName(v)
+		columns[i] = -1
+		if name == "" || name == TagsName {
+			continue
+		}
+		//同名的列可能有多个(如name:client和name:server),优先使用没有被标记排除的列
+		c := -1
+		for j, field := range table.fields {
+			if field.name == name && (c < 0 || table.fields[c].parser == nil) {
+				c = j
+			}
+		}
+		if c < 0 {
+			w.report.Errorf(cellPos(file, NamesRow+1, i), "unknown column:%s", name)
+		} else if c == table.idIndex {
+			idIndex = i
+		} else if table.fields[c].parser == nil {
+			w.report.Errorf(cellPos(file, NamesRow+1, i), "column %s is excluded by tags", name)
+		} else {
+			columns[i] = c
+		}
+	}
+
+	if idIndex < 0 {
+		w.report.Errorf(filePos(file), "not id field")
+		return
+	}
+
+	//使用原始值,转换成float64时超过2^53的int会相等
+	ids := map[interface{}]*Row{}
+	idParser := baseParser(table.fields[table.idIndex].parser)
+	for _, r := range table.rows {
+		if v := r.values[table.idIndex]; v != nil {
+			ids[v.value] = r
+		}
+	}
+
+	for rowNum, row := range rows[DatasRow:] {
+		line := rowNum + DatasRow + 1
+		if row[idIndex] == "" {
+			continue
+		}
+		id, err := idParser.Parse(row[idIndex])
+		if err != nil {
+			w.report.Errorf(cellPos(file, line, idIndex), "parse err:(%v) str:(%s)", err, row[idIndex])
+			continue
+		}
+		r, ok := ids[id.value]
+		if !ok {
+			w.report.Errorf(cellPos(file, line, idIndex), "id not found in base table:%s", row[idIndex])
+			continue
+		}
+		for i, c := range columns {
+			if c < 0 || trim(row[i]) == "" {
+				continue
+			}
+			//覆盖的值可能与基础表原值相同,不做unique检查,其它约束与基础表相同
+			field := table.fields[c]
+			if v, err := withoutUnique(field.parser).Parse(row[i]); err != nil {
+				w.report.Errorf(cellPos(file, line, i), "parse err:(%v) columm:(%s) types:(%s) str:(%s)", err, field.name, field.typeStr, row[i])
+			} else {
+				r.values[c] = v
+			}
+		}
+	}
+}
+
+func (w *Walker) applyOverlays(tables []*Table) {
+	dir := filepath.Join(w.loadPath, OverlaysDir, w.overlay)
+	if f, err := os.Stat(dir); err != nil || !f.IsDir() {
+		w.report.Errorf(filePos(dir), "overlay not found")
+		return
+	}
+
+	byName := map[string]*Table{}
+	for _, t := range tables {
+		byName[t.name] = t
+	}
+
+	filepath.Walk(dir, func(filePath string, f os.FileInfo, _ error) error {
+		if f != nil && !f.IsDir() && strings.Contains(f.Name(), ".xlsx") {
+			name := strings.TrimSuffix(f.Name(), ".xlsx")
+			if table, ok := byName[name]; ok {
+				w.applyOverlay(table, filePath)
+			} else {
+				w.report.Errorf(filePos(filepath.Join(OverlaysDir, w.overlay, f.Name())), "unknown table:%s", name)
+			}
+		}
+		return nil
+	})
+}
@@ -58,6 +58,8 @@ type Parser interface {
 
 type ValueParser struct {
 	valueType int
+	asset     bool //资源路径,需要检查文件是否存在
+	i18n      bool //需要翻译的文本
 }
 
 func (p *ValueParser) ValueType() int {
@@ -91,6 +93,9 @@ func (p *ValueParser) Parse(s string) (*Value, error) {
 		}
 	case typeString:
 		v.value = s
+		if p.asset {
+			err = checkAsset(trim(s))
+		}
 	default:
 		err = fmt.Errorf("invaild type str:%s", s)
 	}
@@ -144,7 +149,7 @@ func (p *ArrayParser) split(s string) (ret []string, err error) {
 		return ret, errors.New("ArrayParser.split bracket mismatch")
 	}
 	s = s[1 : len(s)-1] //去掉头尾括号
-	switch p.elements.(type) {
+	switch baseParser(p.elements).(type) {
 	case *ArrayParser:
 		ret, err = p.splitCompose(s, "[]")
 	case *StructParser:
@@ -270,7 +275,7 @@ func (p *StructParser) readComposeFiledValue(s string, parser Parser, bracket st
 }
 
 func (p *StructParser) readFieldValue(s string, parser Parser) (*Value, string, error) {
-	switch parser.(type) {
+	switch baseParser(parser).(type) {
 	case *ValueParser:
 		i := 0
 		var value string
@@ -371,6 +376,13 @@ func (p *StructParser) Parse(s string) (*Value, error) {
 				})
 			}
 		}
+
+		//检查必填字段
+		for _, name := range p.fieldsArray {
+			if c, ok := p.fields[name].(*ConstraintParser); ok && c.required && st.field(name) == nil {
+				return nil, fmt.Errorf("field required:%s", name)
+			}
+		}
 	}
 	v.value = st
 	return v, nil
@@ -421,8 +433,17 @@ func MakeParser(s string) (Parser, error) {
 		return &ValueParser{valueType: typeBool}, nil
 	case "float":
 		return &ValueParser{valueType: typeFloat}, nil
+	case "asset":
+		return &ValueParser{valueType: typeString, asset: true}, nil
+	case "i18n":
+		return &ValueParser{valueType: typeString, i18n: true}, nil
 	default:
-		if strings.HasSuffix(s, "[]") {
+		var c *ConstraintParser
+		if typeStr, c, err = splitConstraint(s); err != nil {
+			return nil, err
+		} else if c != nil {
+			return makeConstraintParser(typeStr, c)
+		} else if strings.HasSuffix(s, "[]") {
 			s = strings.TrimSuffix(s, "[]")
 			p := &ArrayParser{}
 			if p.elements, err = MakeParser(s); err != nil {
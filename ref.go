@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// 列属性ref=Table,该列的值(数组为每个元素)必须是Table中的id,空单元格和零值不检查
+const refAttr = "ref"
+
+// ref列的元素类型,不是基本类型或基本类型数组时返回nil
+func refElement(p Parser) *ValueParser {
+	switch pp := baseParser(p).(type) {
+	case *ValueParser:
+		return pp
+	case *ArrayParser:
+		v, _ := baseParser(pp.elements).(*ValueParser)
+		return v
+	}
+	return nil
+}
+
+// 检查所有ref列引用的id是否存在
+func (w *Walker) checkRefs(tables []*Table) {
+	byName := map[string]*Table{}
+	for _, t := range tables {
+		byName[t.name] = t
+	}
+	//使用原始值,转换成float64时超过2^53的int会相等
+	ids := map[string]map[interface{}]bool{}
+	for _, t := range tables {
+		for i, field := range t.fields {
+			ref, ok := field.attr(refAttr)
+			if !ok || field.parser == nil {
+				continue
+			}
+			target, ok := byName[ref]
+			if !ok {
+				w.report.Errorf(cellPos(t.file, NamesRow+1, i), "ref: unknown table %s", ref)
+				continue
+			}
+			e := refElement(field.parser)
+			if e == nil || e.ValueType() != baseParser(target.fields[target.idIndex].parser).ValueType() {
+				w.report.Errorf(cellPos(t.file, NamesRow+1, i), "ref: type of %s does not match id of %s", field.name, ref)
+				continue
+			}
+			if ids[ref] == nil {
+				ids[ref] = map[interface{}]bool{}
+				for _, r := range target.rows {
+					if v := r.values[target.idIndex]; v != nil {
+						ids[ref][rawKey(v)] = true
+					}
+				}
+			}
+			for _, r := range t.rows {
+				v := r.values[i]
+				if v == nil || (v.valueType != typeArray && reflect.ValueOf(v.value).IsZero()) {
+					//空单元格解析为零值,不检查
+					continue
+				}
+				values := []*Value{v}
+				if v.valueType == typeArray {
+					values = v.value.(*Array).value
+				}
+				for _, vv := range values {
+					if !ids[ref][rawKey(vv)] {
+						w.report.Errorf(cellPos(t.file, r.line, i), "ref: %s not found in %s", fmt.Sprint(vv.value), ref)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/360EntSecGroup-Skylar/excelize"
+)
+
+const (
+	levelWarn  = 1
+	levelError = 2
+)
+
+// 出错位置,row为excel中的行号(从1开始,0表示整个文件),col为列下标(从0开始,-1表示整行)
+type Pos struct {
+	file string
+	row  int
+	col  int
+}
+
+func (p Pos) String() string {
+	if p.row == 0 {
+		return p.file
+	} else if p.col < 0 {
+		return fmt.Sprintf("%s!%d", p.file, p.row)
+	} else {
+		return fmt.Sprintf("%s!%s%d", p.file, excelize.ToAlphaString(p.col), p.row)
+	}
+}
+
+func (p Pos) less(o Pos) bool {
+	if p.file != o.file {
+		return p.file < o.file
+	} else if p.row != o.row {
+		return p.row < o.row
+	} else {
+		return p.col < o.col
+	}
+}
+
+func filePos(file string) Pos {
+	return Pos{file: file, col: -1}
+}
+
+func rowPos(file string, row int) Pos {
+	return Pos{file: file, row: row, col: -1}
+}
+
+func cellPos(file string, row int, col int) Pos {
+	return Pos{file: file, row: row, col: col}
+}
+
+// 一条诊断信息
+type Diag struct {
+	level int
+	pos   Pos
+	msg   string
+}
+
+func (d *Diag) String() string {
+	if d.level == levelError {
+		return fmt.Sprintf("[error] %s %s", d.pos, d.msg)
+	} else {
+		return fmt.Sprintf("[warn] %s %s", d.pos, d.msg)
+	}
+}
+
+// 收集打表过程中产生的错误和警告,可被多个goroutine同时使用
+type Reporter struct {
+	mu     sync.Mutex
+	diags  []*Diag
+	errors int
+}
+
+func (r *Reporter) add(level int, pos Pos, format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.diags = append(r.diags, &Diag{
+		level: level,
+		pos:   pos,
+		msg:   fmt.Sprintf(format, args...),
+	})
+	if level == levelError {
+		r.errors++
+	}
+}
+
+func (r *Reporter) Errorf(pos Pos, format string, args ...interface{}) {
+	r.add(levelError, pos, format, args...)
+}
+
+func (r *Reporter) Warnf(pos Pos, format string, args ...interface{}) {
+	r.add(levelWarn, pos, format, args...)
+}
+
+func (r *Reporter) Errors() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.errors
+}
+
+// 按位置排序输出所有诊断信息,并清空
+func (r *Reporter) Flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sort.SliceStable(r.diags, func(i, j int) bool {
+		return r.diags[i].pos.less(r.diags[j].pos)
+	})
+	for _, v := range r.diags {
+		log.Println(v)
+	}
+	r.diags = nil
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	stdjson "encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 跨表校验规则,在所有表解析完成后执行
+//
+//	{"name":"商店物品必须存在","table":"Shop","check":"exists(Item, itemId)"}
+//	{"table":"DropGroup","group":"groupId","check":"sum(weight) == 10000"}
+//	{"table":"Skill","group":"skillId","check":"contiguous(level, 1)"}
+//
+// 没有group时check对where过滤后的每一行求值,有group时对每一组求值,
+// 组内直接引用列名得到该组第一行的值,聚合函数对组内所有行求值
+type Rule struct {
+	Name  string `json:"name"`
+	Table string `json:"table"`
+	Where string `json:"where"`
+	Group string `json:"group"`
+	Check string `json:"check"`
+	where expr
+	group expr
+	check expr
+}
+
+type Rules struct {
+	file  string
+	Rules []*Rule `json:"rules"`
+}
+
+func loadRules(path string) (*Rules, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules := &Rules{}
+	if err = stdjson.Unmarshal(b, rules); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	rules.file = path
+	for i, r := range rules.Rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("#%d", i+1)
+		}
+		if r.Table == "" || r.Check == "" {
+			return nil, fmt.Errorf("%s: rule %s: table and check required", path, r.Name)
+		}
+		if r.check, err = parseExpr(r.Check); err != nil {
+			return nil, fmt.Errorf("%s: rule %s: %v", path, r.Name, err)
+		}
+		if r.Where != "" {
+			if r.where, err = parseExpr(r.Where); err != nil {
+				return nil, fmt.Errorf("%s: rule %s: %v", path, r.Name, err)
+			}
+		}
+		if r.Group != "" {
+			if r.group, err = parseExpr(r.Group); err != nil {
+				return nil, fmt.Errorf("%s: rule %s: %v", path, r.Name, err)
+			}
+		}
+	}
+	return rules, nil
+}
+
+func exprKey(v interface{}) string {
+	switch vv := v.(type) {
+	case float64:
+		return strconv.FormatFloat(vv, 'f', -1, 64)
+	case string:
+		return vv
+	default:
+		return fmt.Sprint(vv)
+	}
+}
+
+// exists比较的值,int使用原始值,转换成float64时超过2^53的int会相等
+func rawKey(v *Value) interface{} {
+	switch v.valueType {
+	case typeInt:
+		switch n := v.value.(type) {
+		case int64:
+			return n
+		case int:
+			return int64(n)
+		}
+	case typeFloat, typeString, typeBool:
+		return v.value
+	}
+	return exprKey(v.toExprValue())
+}
+
+// 表达式的值x是否在keys中,整数值的float64按int查找
+func hasKey(keys map[interface{}]bool, x interface{}) bool {
+	switch xx := x.(type) {
+	case float64:
+		if xx == math.Trunc(xx) && math.Abs(xx) < math.MaxInt64 && keys[int64(xx)] {
+			return true
+		}
+		return keys[xx]
+	case string, bool:
+		return keys[xx]
+	default:
+		return keys[exprKey(x)]
+	}
+}
+
+func (t *Table) column(name string) int {
+	for i, v := range t.fields {
+		if v.parser != nil && v.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+type validator struct {
+	tables map[string]*Table
+	keys   map[string]map[interface{}]bool //table.column -> 该列所有值
+}
+
+// 返回table中col列所有值的集合
+func (v *validator) columnKeys(table string, col string) (map[interface{}]bool, error) {
+	k := table + "." + col
+	if keys, ok := v.keys[k]; ok {
+		return keys, nil
+	}
+	t, ok := v.tables[table]
+	if !ok {
+		return nil, fmt.Errorf("unknown table %s", table)
+	}
+	i := t.column(col)
+	if i < 0 {
+		return nil, fmt.Errorf("unknown column %s.%s", table, col)
+	}
+	keys := map[interface{}]bool{}
+	for _, r := range t.rows {
+		if r.values[i] != nil {
+			keys[rawKey(r.values[i])] = true
+		}
+	}
+	v.keys[k] = keys
+	return keys, nil
+}
+
+type ruleEnv struct {
+	v     *validator
+	table *Table
+	rows  []*Row //行规则只有一行,分组规则为组内所有行
+	group bool
+	col   int //第一个被引用的列,用于报告错误位置
+}
+
+func (e *ruleEnv) lookup(name string) (interface{}, error) {
+	i := e.table.column(name)
+	if i < 0 {
+		return nil, fmt.Errorf("unknown column %s", name)
+	}
+	if e.col < 0 {
+		e.col = i
+	}
+	if v := e.rows[0].values[i]; v != nil {
+		return v.toExprValue(), nil
+	}
+	return nil, nil
+}
+
+// 聚合函数的参数,分组规则对组内每一行求值,否则参数必须是数组
+func (e *ruleEnv) collect(arg expr) ([]interface{}, error) {
+	if e.group {
+		var values []interface{}
+		for _, r := range e.rows {
+			v, err := arg.eval(&ruleEnv{v: e.v, table: e.table, rows: []*Row{r}, col: -1})
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	}
+	v, err := arg.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if a, ok := v.([]interface{}); ok {
+		return a, nil
+	}
+	return nil, fmt.Errorf("%v is not array", v)
+}
+
+func (e *ruleEnv) numbers(name string, arg expr) ([]float64, error) {
+	values, err := e.collect(arg)
+	if err != nil {
+		return nil, err
+	}
+	var numbers []float64
+	for _, v := range values {
+		if n, ok := v.(float64); ok {
+			numbers = append(numbers, n)
+		} else {
+			return nil, fmt.Errorf("%s: %v is not number", name, v)
+		}
+	}
+	return numbers, nil
+}
+
+func (e *ruleEnv) exists(args []expr) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("exists need 2 args")
+	}
+	//exists(Table, x)检查id列,exists(Table.column, x)检查指定列
+	var table, col string
+	switch a := args[0].(type) {
+	case *identExpr:
+		table = a.name
+		if t, ok := e.v.tables[table]; ok {
+			col = t.fields[t.idIndex].name
+		}
+	case *selectorExpr:
+		if t, ok := a.x.(*identExpr); ok {
+			table, col = t.name, a.name
+		}
+	}
+	if table == "" {
+		return nil, fmt.Errorf("exists: invaild table")
+	}
+	keys, err := e.v.columnKeys(table, col)
+	if err != nil {
+		return nil, err
+	}
+	//直接引用列时比较原始值
+	if id, ok := args[1].(*identExpr); ok {
+		if i := e.table.column(id.name); i >= 0 {
+			if e.col < 0 {
+				e.col = i
+			}
+			v := e.rows[0].values[i]
+			if v == nil {
+				return false, nil
+			}
+			values := []*Value{v}
+			if v.valueType == typeArray {
+				values = v.value.(*Array).value
+			}
+			for _, vv := range values {
+				if !keys[rawKey(vv)] {
+					return false, nil
+				}
+			}
+			return true, nil
+		}
+	}
+	x, err := args[1].eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if a, ok := x.([]interface{}); ok {
+		for _, vv := range a {
+			if !hasKey(keys, vv) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+	return hasKey(keys, x), nil
+}
+
+func (e *ruleEnv) call(name string, args []expr) (interface{}, error) {
+	switch name {
+	case "exists":
+		return e.exists(args)
+	case "count":
+		if len(args) == 0 {
+			return float64(len(e.rows)), nil
+		}
+		values, err := e.collect(args[0])
+		return float64(len(values)), err
+	case "len":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("len need 1 arg")
+		}
+		x, err := args[0].eval(e)
+		if err != nil {
+			return nil, err
+		}
+		switch xx := x.(type) {
+		case []interface{}:
+			return float64(len(xx)), nil
+		case string:
+			return float64(len([]rune(xx))), nil
+		}
+		return nil, fmt.Errorf("len: invaild arg %v", x)
+	case "sum", "min", "max":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s need 1 arg", name)
+		}
+		numbers, err := e.numbers(name, args[0])
+		if err != nil {
+			return nil, err
+		}
+		if len(numbers) == 0 {
+			return 0.0, nil
+		}
+		ret := numbers[0]
+		for _, n := range numbers[1:] {
+			switch {
+			case name == "sum":
+				ret += n
+			case name == "min" && n < ret:
+				ret = n
+			case name == "max" && n > ret:
+				ret = n
+			}
+		}
+		return ret, nil
+	case "contiguous":
+		//排序后是否为连续整数,可指定起始值
+		if len(args) != 1 && len(args) != 2 {
+			return nil, fmt.Errorf("contiguous need 1 or 2 args")
+		}
+		numbers, err := e.numbers(name, args[0])
+		if err != nil {
+			return nil, err
+		}
+		sort.Float64s(numbers)
+		if len(args) == 2 {
+			start, err := args[1].eval(e)
+			if err != nil {
+				return nil, err
+			}
+			if len(numbers) > 0 && numbers[0] != start {
+				return false, nil
+			}
+		}
+		for i := 1; i < len(numbers); i++ {
+			if numbers[i] != numbers[i-1]+1 {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+	return nil, fmt.Errorf("unknown function %s", name)
+}
+
+func (r *Rule) filter(v *validator, table *Table) ([]*Row, error) {
+	if r.where == nil {
+		return table.rows, nil
+	}
+	var rows []*Row
+	for _, row := range table.rows {
+		ok, err := r.where.eval(&ruleEnv{v: v, table: table, rows: []*Row{row}, col: -1})
+		if err != nil {
+			return nil, err
+		} else if b, _ := ok.(bool); b {
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+func (r *Rule) run(v *validator, table *Table, report *Reporter) {
+	rows, err := r.filter(v, table)
+	if err != nil {
+		report.Errorf(filePos(table.file), "rule %s: %v", r.Name, err)
+		return
+	}
+
+	if r.group == nil {
+		for _, row := range rows {
+			env := &ruleEnv{v: v, table: table, rows: []*Row{row}, col: -1}
+			ok, err := r.check.eval(env)
+			pos := rowPos(table.file, row.line)
+			if env.col >= 0 {
+				pos = cellPos(table.file, row.line, env.col)
+			}
+			if err != nil {
+				report.Errorf(pos, "rule %s: %v", r.Name, err)
+			} else if b, isBool := ok.(bool); !isBool {
+				report.Errorf(pos, "rule %s: check must return bool", r.Name)
+			} else if !b {
+				report.Errorf(pos, "rule %s failed: %s", r.Name, r.Check)
+			}
+		}
+		return
+	}
+
+	var keys []string
+	groups := map[string][]*Row{}
+	for _, row := range rows {
+		k, err := r.group.eval(&ruleEnv{v: v, table: table, rows: []*Row{row}, col: -1})
+		if err != nil {
+			report.Errorf(rowPos(table.file, row.line), "rule %s: %v", r.Name, err)
+			return
+		}
+		key := exprKey(k)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], row)
+	}
+
+	for _, key := range keys {
+		group := groups[key]
+		ok, err := r.check.eval(&ruleEnv{v: v, table: table, rows: group, group: true, col: -1})
+		pos := rowPos(table.file, group[0].line)
+		if err != nil {
+			report.Errorf(pos, "rule %s: %v", r.Name, err)
+		} else if b, isBool := ok.(bool); !isBool {
+			report.Errorf(pos, "rule %s: check must return bool", r.Name)
+		} else if !b {
+			var lines []string
+			for _, row := range group {
+				lines = append(lines, strconv.Itoa(row.line))
+			}
+			report.Errorf(pos, "rule %s failed: %s group:(%s) rows:(%s)", r.Name, r.Check, key, strings.Join(lines, ","))
+		}
+	}
+}
+
+func (rules *Rules) validate(tables []*Table, report *Reporter) {
+	v := &validator{
+		tables: map[string]*Table{},
+		keys:   map[string]map[interface{}]bool{},
+	}
+	for _, t := range tables {
+		v.tables[t.name] = t
+	}
+	for _, r := range rules.Rules {
+		if table, ok := v.tables[r.Table]; !ok {
+			report.Errorf(filePos(rules.file), "rule %s: unknown table %s", r.Name, r.Table)
+		} else {
+			r.run(v, table, report)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/360EntSecGroup-Skylar/excelize"
+)
+
+// 解析单元格坐标,如B3,返回(行下标,列下标),均从0开始
+func parseAxis(axis string) (int, int) {
+	row, _ := strconv.Atoi(strings.TrimLeft(axis, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz$"))
+	return row - 1, cellCol(axis)
+}
+
+// 通过workbook.xml.rels找到sheet对应的xml文件,文件名不一定与sheetId或sheet的顺序对应
+func sheetPart(xlsx *excelize.File, sheet string) (string, error) {
+	if xlsx.WorkBook != nil && xlsx.WorkBookRels != nil {
+		for _, s := range xlsx.WorkBook.Sheets.Sheet {
+			if s.Name != sheet {
+				continue
+			}
+			for _, rel := range xlsx.WorkBookRels.Relationships {
+				if rel.ID == s.ID {
+					if strings.HasPrefix(rel.Target, "/") {
+						return rel.Target[1:], nil
+					}
+					return path.Join("xl", rel.Target), nil
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("worksheet of sheet %s not found", sheet)
+}
+
+// 读取xlsx文件当前sheet的所有行,file用于报告位置
+func (w *Walker) readSheet(filePath string, file string) ([][]string, error) {
+	xlsx, err := excelize.OpenFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	index := xlsx.GetActiveSheetIndex()
+	sheet := xlsx.GetSheetName(index)
+	if w.skipHidden && !xlsx.GetSheetVisible(sheet) {
+		return nil, nil
+	}
+
+	part, err := sheetPart(xlsx, sheet)
+	if err != nil {
+		return nil, err
+	}
+	rows := xlsx.GetRows(sheet)
+	if xlsx.Sheet[part] == nil {
+		//excelize只能读取名为sheetN.xml的文件
+		return nil, fmt.Errorf("unsupported worksheet %s of sheet %s", part, sheet)
+	}
+	if formulas := sheetFormulas(xlsx, part); len(formulas) > 0 {
+		w.evalFormulas(file, rows, formulas)
+	}
+	if w.fillMerged {
+		fillMerged(xlsx, sheet, rows)
+	}
+	if w.skipHidden {
+		clearHidden(xlsx, part, rows)
+	}
+	return rows, nil
+}
+
+// 合并单元格只有左上角有值,把这个值填充到整个区域
+func fillMerged(xlsx *excelize.File, sheet string, rows [][]string) {
+	for _, m := range xlsx.GetMergeCells(sheet) {
+		r1, c1 := parseAxis(m.GetStartAxis())
+		r2, c2 := parseAxis(m.GetEndAxis())
+		if r1 < 0 || r1 >= len(rows) || c1 >= len(rows[r1]) {
+			continue
+		}
+		v := rows[r1][c1]
+		for r := r1; r <= r2 && r < len(rows); r++ {
+			for c := c1; c <= c2 && c < len(rows[r]); c++ {
+				rows[r][c] = v
+			}
+		}
+	}
+}
+
+// 清空隐藏的数据行,清空隐藏列的名字使其被忽略,part为sheetPart返回的文件
+func clearHidden(xlsx *excelize.File, part string, rows [][]string) {
+	ws := xlsx.Sheet[part]
+	if ws == nil {
+		return
+	}
+	for _, row := range ws.SheetData.Row {
+		if r := row.R - 1; row.Hidden && r >= DatasRow && r < len(rows) {
+			for c := range rows[r] {
+				rows[r][c] = ""
+			}
+		}
+	}
+	if ws.Cols != nil && len(rows) > NamesRow {
+		for _, col := range ws.Cols.Col {
+			if col.Hidden {
+				for c := col.Min - 1; c < col.Max && c < len(rows[NamesRow]); c++ {
+					rows[NamesRow][c] = ""
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// 流式读取xlsx的当前sheet,每次只解码一行,共享字符串表整体加载。
+// 单元格直接使用文件中保存的值:不计算公式(使用缓存值),不应用数字格式,不处理合并单元格
+type sheetReader struct {
+	zr     *zip.ReadCloser
+	rc     io.ReadCloser
+	d      *xml.Decoder
+	sst    []string
+	hidden bool         //sheet是否被隐藏
+	cols   map[int]bool //隐藏的列
+	line   int          //上一次读到的行号
+}
+
+type streamWorkbook struct {
+	Sheets []struct {
+		State string `xml:"state,attr"`
+		ID    string `xml:"id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type streamRels struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type streamRow struct {
+	R      int  `xml:"r,attr"`
+	Hidden bool `xml:"hidden,attr"`
+	C      []struct {
+		R  string `xml:"r,attr"`
+		T  string `xml:"t,attr"`
+		V  string `xml:"v"`
+		IS struct {
+			T string `xml:"t"`
+		} `xml:"is"`
+	} `xml:"c"`
+}
+
+func decodeZipXML(f *zip.File, v interface{}) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(rc).Decode(v)
+}
+
+// 逐个解码<si>,富文本把所有<r>的文本连接起来
+func readSharedStrings(f *zip.File) ([]string, error) {
+	if f == nil {
+		return nil, nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var sst []string
+	d := xml.NewDecoder(rc)
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return sst, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if e, ok := t.(xml.StartElement); ok && e.Name.Local == "si" {
+			var si struct {
+				T string `xml:"t"`
+				R []struct {
+					T string `xml:"t"`
+				} `xml:"r"`
+			}
+			if err = d.DecodeElement(&si, &e); err != nil {
+				return nil, err
+			}
+			if len(si.R) > 0 {
+				var b strings.Builder
+				for _, r := range si.R {
+					b.WriteString(r.T)
+				}
+				si.T = b.String()
+			}
+			sst = append(sst, si.T)
+		}
+	}
+}
+
+// 读取<sheetData>之前的部分,返回sheet是否被选中和隐藏的列,读取后d位于第一行之前
+func scanSheetHead(d *xml.Decoder) (bool, map[int]bool, error) {
+	selected := false
+	cols := map[int]bool{}
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return selected, cols, nil
+		} else if err != nil {
+			return false, nil, err
+		}
+		e, ok := t.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch e.Name.Local {
+		case "sheetView":
+			for _, a := range e.Attr {
+				if a.Name.Local == "tabSelected" && (a.Value == "1" || a.Value == "true") {
+					selected = true
+				}
+			}
+		case "col":
+			var col struct {
+				Min    int  `xml:"min,attr"`
+				Max    int  `xml:"max,attr"`
+				Hidden bool `xml:"hidden,attr"`
+			}
+			if err = d.DecodeElement(&col, &e); err != nil {
+				return false, nil, err
+			}
+			if col.Hidden {
+				for c := col.Min - 1; c < col.Max; c++ {
+					cols[c] = true
+				}
+			}
+		case "sheetData":
+			return selected, cols, nil
+		}
+	}
+}
+
+func openSheetReader(filePath string) (*sheetReader, error) {
+	zr, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, err
+	}
+	r := &sheetReader{zr: zr}
+	if err = r.open(); err != nil {
+		zr.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *sheetReader) open() error {
+	files := map[string]*zip.File{}
+	for _, f := range r.zr.File {
+		files[f.Name] = f
+	}
+	if files["xl/workbook.xml"] == nil || files["xl/_rels/workbook.xml.rels"] == nil {
+		return fmt.Errorf("invalid xlsx")
+	}
+	var wb streamWorkbook
+	if err := decodeZipXML(files["xl/workbook.xml"], &wb); err != nil {
+		return err
+	}
+	var rels streamRels
+	if err := decodeZipXML(files["xl/_rels/workbook.xml.rels"], &rels); err != nil {
+		return err
+	}
+	var err error
+	if r.sst, err = readSharedStrings(files["xl/sharedStrings.xml"]); err != nil {
+		return err
+	}
+
+	//与excelize一致,使用tabSelected的sheet,没有时使用第一个sheet
+	sheets := make([]*zip.File, len(wb.Sheets))
+	for i, s := range wb.Sheets {
+		for _, rel := range rels.Relationships {
+			if rel.ID == s.ID {
+				if strings.HasPrefix(rel.Target, "/") {
+					sheets[i] = files[strings.TrimPrefix(rel.Target, "/")]
+				} else {
+					sheets[i] = files[path.Join("xl", rel.Target)]
+				}
+			}
+		}
+	}
+	active := -1
+	for i, f := range sheets {
+		if f == nil {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		selected, _, err := scanSheetHead(xml.NewDecoder(rc))
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		if selected || active < 0 {
+			active = i
+		}
+		if selected {
+			break
+		}
+	}
+	if active < 0 {
+		return fmt.Errorf("no sheet")
+	}
+
+	state := wb.Sheets[active].State
+	r.hidden = state != "" && state != "visible"
+	if r.rc, err = sheets[active].Open(); err != nil {
+		return err
+	}
+	r.d = xml.NewDecoder(r.rc)
+	if _, r.cols, err = scanSheetHead(r.d); err != nil {
+		r.rc.Close()
+		return err
+	}
+	return nil
+}
+
+// 返回下一个非空行及其行号(从1开始),读完返回io.EOF
+func (r *sheetReader) next() ([]string, int, bool, error) {
+	for {
+		t, err := r.d.Token()
+		if err != nil {
+			return nil, 0, false, err
+		}
+		switch e := t.(type) {
+		case xml.StartElement:
+			if e.Name.Local != "row" {
+				continue
+			}
+			var row streamRow
+			if err = r.d.DecodeElement(&row, &e); err != nil {
+				return nil, 0, false, err
+			}
+			if row.R > 0 {
+				r.line = row.R
+			} else {
+				r.line++
+			}
+			var values []string
+			for i, c := range row.C {
+				col := i
+				if c.R != "" {
+					col = cellCol(c.R)
+				}
+				v := c.V
+				switch c.T {
+				case "s":
+					if n, err := strconv.Atoi(c.V); err == nil && n >= 0 && n < len(r.sst) {
+						v = r.sst[n]
+					}
+				case "inlineStr":
+					v = c.IS.T
+				}
+				for len(values) <= col {
+					values = append(values, "")
+				}
+				values[col] = v
+			}
+			return values, r.line, row.Hidden, nil
+		case xml.EndElement:
+			if e.Name.Local == "sheetData" {
+				return nil, 0, false, io.EOF
+			}
+		}
+	}
+}
+
+func (r *sheetReader) Close() {
+	r.rc.Close()
+	r.zr.Close()
+}
+
+const streamMark = "\x00"
+
+// 流式输出,每解析一行写入一行。先写入临时文件,全部表都没有错误时再改名
+type streamWriter struct {
+	filename string
+	f        *os.File
+	w        *bufio.Writer
+	tail     string
+	table    *Table
+	row      func(*strings.Builder, *Table, *Row)
+	builder  strings.Builder
+	count    int
+}
+
+// 用标记代替Data执行模板,标记前后分别是文件头和文件尾
+func newStreamWriter(tmpl *template.Template, data interface{}, filename string, table *Table, row func(*strings.Builder, *Table, *Row)) *streamWriter {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		panic(err)
+	}
+	head, tail, _ := strings.Cut(b.String(), streamMark)
+
+	os.MkdirAll(path.Dir(filename), os.ModePerm)
+	f, err := os.Create(filename + ".tmp")
+	if err != nil {
+		panic(err)
+	}
+	s := &streamWriter{
+		filename: filename,
+		f:        f,
+		w:        bufio.NewWriter(f),
+		tail:     tail,
+		table:    table,
+		row:      row,
+	}
+	s.w.WriteString(head)
+	return s
+}
+
+func streamJson(tmpl *template.Template, writePath string, table *Table) *streamWriter {
+	return newStreamWriter(tmpl, json{Data: streamMark}, fmt.Sprintf("%s/%s.json", writePath, table.name), table, jsonRow)
+}
+
+func streamLua(tmpl *template.Template, writePath string, table *Table) *streamWriter {
+	return newStreamWriter(tmpl, lua{table.name, streamMark}, fmt.Sprintf("%s/%s.lua", writePath, table.name), table, luaRow)
+}
+
+func (s *streamWriter) WriteRow(r *Row) {
+	s.builder.Reset()
+	if s.count > 0 {
+		s.builder.WriteString(",\n")
+	}
+	s.row(&s.builder, s.table, r)
+	if _, err := s.w.WriteString(s.builder.String()); err != nil {
+		panic(err)
+	}
+	s.count++
+}
+
+func (s *streamWriter) Close() {
+	s.w.WriteString(s.tail)
+	if err := s.w.Flush(); err != nil {
+		panic(err)
+	}
+	s.f.Close()
+}
+
+// ok为true时把临时文件改名为输出文件,否则删除临时文件
+func (s *streamWriter) commit(ok bool) {
+	if !ok {
+		os.Remove(s.filename + ".tmp")
+	} else if err := os.Rename(s.filename+".tmp", s.filename); err != nil {
+		panic(err)
+	} else {
+		log.Printf("%s Write ok\n", s.filename)
+	}
+}
+
+// 流式读取并输出一个表,只保留列定义和用于检查重复的id集合
+func (w *Walker) streamTable(filePath string) (*Table, *streamWriter) {
+	filename := path.Base(filePath)
+	r, err := openSheetReader(filePath)
+	if err != nil {
+		w.report.Errorf(filePos(filename), "OpenFileError:%v", err)
+		return nil, nil
+	}
+	defer r.Close()
+	if w.skipHidden && r.hidden {
+		return nil, nil
+	}
+
+	var header [DatasRow][]string
+	var table *Table
+	var out *streamWriter
+	ids := map[interface{}]int{}
+	for {
+		row, line, hidden, err := r.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			w.report.Errorf(filePos(filename), "ReadError:%v", err)
+			break
+		}
+		if line <= DatasRow {
+			header[line-1] = row
+			continue
+		}
+		if table == nil {
+			names := header[NamesRow]
+			if w.skipHidden {
+				for c := range r.cols {
+					if c < len(names) {
+						names[c] = ""
+					}
+				}
+			}
+			types := make([]string, len(names))
+			copy(types, header[TypesRow])
+			if table = w.makeTable(filePath, names, types); table == nil {
+				return nil, nil
+			}
+			for i, field := range table.fields {
+				if _, ok := field.attr(constAttr); ok {
+					//常量由所有行生成,流式打表时不保留行
+					w.report.Errorf(cellPos(table.file, NamesRow+1, i), "const can not be used with stream")
+					return nil, nil
+				}
+				if _, ok := field.attr(refAttr); ok {
+					//引用检查需要所有表的id,流式打表时不保留行
+					w.report.Errorf(cellPos(table.file, NamesRow+1, i), "ref can not be used with stream")
+					return nil, nil
+				}
+			}
+			if w.funcStream != nil {
+				out = w.funcStream(w.tmpl, w.writePath, table)
+			}
+		}
+		if w.skipHidden && hidden {
+			continue
+		}
+		for len(row) < len(table.fields) {
+			row = append(row, "")
+		}
+		if rr := w.parseDataRow(table, line, row, ids); rr != nil {
+			table.count++
+			if out != nil {
+				out.WriteRow(rr)
+			}
+		}
+	}
+	if out != nil {
+		out.Close()
+	}
+	return table, out
+}
+
+// 流式打表,不支持需要所有行都在内存中的功能(autoid,overlay,rules,i18n),返回的表不包含数据行
+func (w *Walker) walkStream() []*Table {
+	files := w.files()
+	results := make([]*Table, len(files))
+	outs := make([]*streamWriter, len(files))
+	w.parallel(len(files), func(i int) {
+		start := time.Now()
+		results[i], outs[i] = w.streamTable(files[i])
+		if results[i] != nil {
+			results[i].elapsed = time.Since(start)
+		}
+	})
+	var tables []*Table
+	for _, v := range results {
+		if v != nil {
+			tables = append(tables, v)
+		}
+	}
+
+	ok := w.report.Errors() == 0
+	for _, v := range outs {
+		if v != nil {
+			v.commit(ok)
+		}
+	}
+	if !ok || w.funcOutput == nil {
+		return tables
+	}
+	if w.funcStream == nil {
+		//go模式只需要列定义
+		for _, v := range tables {
+			log.Printf("%s Write ok\n", w.funcOutput(w.tmpl, w.writePath, v))
+		}
+	}
+	if w.funcOk != nil {
+		w.funcOk(w.writePath)
+	}
+	return tables
+}
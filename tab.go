@@ -1,205 +1,605 @@
-package main
-
-import (
-	"flag"
-	"fmt"
-	"os"
-	"path"
-	"path/filepath"
-	"strings"
-	"sync"
-	"text/template"
-
-	"github.com/360EntSecGroup-Skylar/excelize"
-)
-
-const (
-	typeInt    = 1
-	typeString = 2
-	typeBool   = 3
-	typeFloat  = 4
-	typeArray  = 5
-	typeStruct = 6
-)
-
-type Array struct {
-	value []*Value
-}
-
-type Field struct {
-	name  string
-	value *Value
-}
-
-type Struct struct {
-	fields []*Field
-}
-
-type Value struct {
-	valueType int
-	value     interface{}
-}
-
-type Column struct {
-	name   string
-	parser Parser
-}
-
-type Table struct {
-	name   string
-	fields []*Column
-}
-
-type Walker struct {
-	loadPath   string
-	writePath  string
-	tmpl       *template.Template
-	funcOutput func(*template.Template, string, []string, []string, [][]string, *Table, int)
-	funcOk     func(string)
-	ignore     map[string]bool
-}
-
-const NamesRow = 0  //名字定义所在的行
-const TypesRow = 1  //类型定义所在行
-const DatasRow = 3  //数据起始行
-const IdName = "id" //索引列的名字
-
-func (w *Walker) checkColumn(s string) (string, bool) {
-	v := strings.Split(s, ":")
-	if v[0] == "" {
-		//名字为空字符串
-		return "", false
-	} else if len(v) > 1 && w.ignore[v[1]] {
-		//标记在忽略列表中
-		return "", false
-	} else {
-		return v[0], true
-	}
-}
-
-func (w *Walker) walk() {
-	var wait sync.WaitGroup
-	if err := filepath.Walk(w.loadPath, func(filePath string, f os.FileInfo, _ error) error {
-		if f != nil && !f.IsDir() {
-			wait.Add(1)
-			go func() {
-				filename := f.Name()
-				defer func() {
-					wait.Done()
-				}()
-				if strings.Contains(filename, ".xlsx") {
-					table := &Table{
-						name: strings.TrimSuffix(filename, ".xlsx"),
-					}
-
-					xlsx, err := excelize.OpenFile(path.Join(w.loadPath, filename))
-					if err != nil {
-						panic(err)
-					}
-
-					rows := xlsx.GetRows(xlsx.GetSheetName(xlsx.GetActiveSheetIndex()))
-
-					names := rows[NamesRow]
-					types := rows[TypesRow]
-					if len(rows) <= DatasRow {
-						return
-					}
-					rows = rows[DatasRow:]
-
-					idIndex := -1
-
-					for i := 0; i < len(names); i++ {
-
-						if colName, ok := w.checkColumn(names[i]); ok {
-							if colName == IdName {
-								idIndex = i
-							}
-							if parser, err := MakeParser(types[i]); err != nil {
-								panic(fmt.Sprintf("MakeParserError:%v file:%v column:%v", err, filename, names[i]))
-							} else {
-								col := &Column{
-									name:   colName,
-									parser: parser,
-								}
-								table.fields = append(table.fields, col)
-							}
-						} else {
-							table.fields = append(table.fields, &Column{})
-						}
-					}
-
-					if idIndex < 0 {
-						panic("not id field")
-					}
-
-					w.funcOutput(w.tmpl, w.writePath, names, types, rows, table, idIndex)
-				}
-			}()
-		}
-		return nil
-	}); err != nil {
-		panic(err)
-	}
-	wait.Wait()
-	if w.funcOk != nil {
-		w.funcOk(w.writePath)
-	}
-}
-
-func main() {
-	input := flag.String("input", "./excel", "path of xlsx")
-	output := flag.String("output", "./lua", "path of output files")
-	gopackage := flag.String("package", "json", "package of go")
-	mode := flag.String("mode", "json", "lua|json|go")
-	serverOnly := flag.String("server", "false", "true|false")
-	flag.Parse()
-
-	var fn func(tmpl *template.Template, writePath string, colNames []string, types []string, rows [][]string, tab *Table, idIdx int)
-	var walkOk func(writePath string)
-	var tmpl *template.Template
-	var err error
-
-	switch *mode {
-	case "lua":
-		fn = outputLua
-		tmpl, err = template.New("test").Parse(luaTemplate)
-		if err != nil {
-			panic(err)
-		}
-	case "json":
-		fn = outputJson
-		tmpl, err = template.New("test").Parse(jsonTemplate)
-		if err != nil {
-			panic(err)
-		}
-	case "go":
-		j := &goStruct{
-			Package: *gopackage,
-			str:     strings.Builder{},
-		}
-		fn = j.outputGoJson
-		walkOk = j.walkOk
-		tmpl, err = template.New("test").Parse(goTemplate)
-		if err != nil {
-			panic(err)
-		}
-	default:
-		panic("unsupport mode")
-	}
-
-	w := &Walker{
-		loadPath:   *input,
-		writePath:  *output,
-		tmpl:       tmpl,
-		funcOutput: fn,
-		funcOk:     walkOk,
-		ignore:     map[string]bool{"annotation": true},
-	}
-
-	if *serverOnly == "true" {
-		//打服务端表，将所有标记为client的字段加入忽略列表
-		w.ignore["client"] = true
-	}
-
-	w.walk()
-}
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	typeInt    = 1
+	typeString = 2
+	typeBool   = 3
+	typeFloat  = 4
+	typeArray  = 5
+	typeStruct = 6
+)
+
+type Array struct {
+	value []*Value
+}
+
+type Field struct {
+	name  string
+	value *Value
+}
+
+type Struct struct {
+	fields []*Field
+}
+
+func (s *Struct) field(name string) *Value {
+	for _, v := range s.fields {
+		if v.name == name {
+			return v.value
+		}
+	}
+	return nil
+}
+
+type Value struct {
+	valueType int
+	value     interface{}
+}
+
+type Column struct {
+	name    string
+	typeStr string
+	tags    []string
+	parser  Parser
+	locales []*localeColumn   //合并后的多语言列
+	attrs   map[string]string //列属性,如index
+}
+
+func (c *Column) attr(name string) (string, bool) {
+	v, ok := c.attrs[name]
+	return v, ok
+}
+
+type Row struct {
+	line   int      //excel中的行号
+	id     string   //id列的原始字符串
+	values []*Value //与Table.fields一一对应,被忽略的列为nil
+}
+
+type Table struct {
+	name      string
+	file      string
+	fields    []*Column
+	idIndex   int
+	tagsIndex int //行标记列,没有时为-1
+	rows      []*Row
+	count     int           //流式打表时输出的行数
+	elapsed   time.Duration //加载和输出的耗时
+}
+
+type Walker struct {
+	loadPath   string
+	writePath  string
+	tmpl       *template.Template
+	funcOutput func(*template.Template, string, *Table) string //返回写入的文件名
+	funcStream func(*template.Template, string, *Table) *streamWriter
+	funcOk     func(string)
+	//go模式生成的包级标识符,常量名不能与其重名
+	funcNames  func([]*Table) map[string]bool
+	tags       expr //列标记表达式,为nil时输出所有列
+	report     *Reporter
+	rules      *Rules
+	autoId     bool   //为没有id的行自动分配id
+	overlay    string //覆盖表的名字,对应loadPath/overlays/名字
+	i18n       *I18n
+	locale     string //多语言列只输出该语言,为空时输出所有语言
+	fillMerged bool   //合并单元格中的每个单元格都使用左上角的值
+	skipHidden bool   //忽略隐藏的行,列和sheet
+	stream     bool   //逐行读取和输出,内存中不保留数据行
+	jobs       int    //同时处理的表的数量,<=0时为cpu数量
+}
+
+const NamesRow = 0       //名字定义所在的行
+const TypesRow = 1       //类型定义所在行
+const DatasRow = 3       //数据起始行
+const IdName = "id"      //索引列的名字
+const TagsName = "#tags" //行标记列的名字
+
+func (w *Walker) checkColumn(s string) (string, []string, map[string]string, bool) {
+	name, tags := splitColumnName(s)
+	if name == "" {
+		//名字为空字符串
+		return "", nil, nil, false
+	}
+	for _, v := range tags {
+		if v == annotationTag {
+			return name, nil, nil, false
+		}
+	}
+	tags, attrs := splitAttrs(tags)
+	if !matchTags(w.tags, tags) {
+		//标记不满足-tags表达式
+		return name, nil, nil, false
+	}
+	return name, tags, attrs, true
+}
+
+// 读取xlsx文件,生成列定义,出错返回nil
+func (w *Walker) loadTable(filePath string) (*Table, [][]string) {
+	filename := filepath.Base(filePath)
+
+	rows, err := w.readSheet(filePath, filename)
+	if err != nil {
+		w.report.Errorf(filePos(filename), "OpenFileError:%v", err)
+		return nil, nil
+	}
+
+	if len(rows) <= DatasRow {
+		return nil, nil
+	}
+
+	if table := w.makeTable(filePath, rows[NamesRow], rows[TypesRow]); table != nil {
+		return table, rows[DatasRow:]
+	} else {
+		return nil, nil
+	}
+}
+
+// 根据名字行和类型行生成列定义,出错返回nil
+func (w *Walker) makeTable(filePath string, names []string, types []string) *Table {
+	filename := filepath.Base(filePath)
+	table := &Table{
+		name:      strings.TrimSuffix(filename, ".xlsx"),
+		file:      filename,
+		idIndex:   -1,
+		tagsIndex: -1,
+	}
+
+	ok := true
+	for i := 0; i < len(names); i++ {
+		if trim(names[i]) == TagsName {
+			//行标记列不输出
+			table.tagsIndex = i
+			table.fields = append(table.fields, &Column{})
+		} else if colName, tags, attrs, include := w.checkColumn(names[i]); include {
+			if colName == IdName {
+				table.idIndex = i
+			}
+			if parser, err := MakeParser(types[i]); err != nil {
+				w.report.Errorf(cellPos(filename, TypesRow+1, i), "MakeParserError:%v column:%v", err, names[i])
+				ok = false
+			} else {
+				col := &Column{
+					name:    colName,
+					typeStr: types[i],
+					tags:    tags,
+					parser:  parser,
+					attrs:   attrs,
+				}
+				if err = col.checkAttrs(); err != nil {
+					w.report.Errorf(cellPos(filename, NamesRow+1, i), "%v column:%v", err, names[i])
+					ok = false
+				}
+				table.fields = append(table.fields, col)
+			}
+		} else {
+			//被忽略的列只保留名字
+			table.fields = append(table.fields, &Column{name: colName})
+		}
+	}
+
+	if table.idIndex < 0 {
+		w.report.Errorf(filePos(filename), "not id field")
+		ok = false
+	}
+
+	if ok {
+		ok = w.foldLocales(table)
+	}
+
+	if ok {
+		return table
+	} else {
+		return nil
+	}
+}
+
+// 除id外是否还填写了其它列
+func (table *Table) hasData(row []string) bool {
+	for i, field := range table.fields {
+		if field.parser != nil && i != table.idIndex && trim(row[i]) != "" {
+			return true
+		}
+		for _, v := range field.locales {
+			if trim(row[v.index]) != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (w *Walker) parseRow(table *Table, line int, row []string) *Row {
+	r := &Row{
+		line:   line,
+		id:     row[table.idIndex],
+		values: make([]*Value, len(table.fields)),
+	}
+	for i, field := range table.fields {
+		if field.locales != nil {
+			r.values[i], _ = w.parseLocales(table, field, line, row)
+		} else if field.parser != nil {
+			if v, err := field.parser.Parse(row[i]); err != nil {
+				w.report.Errorf(cellPos(table.file, line, i), "parse err:(%v) columm:(%s) types:(%s) str:(%s)", err, field.name, field.typeStr, row[i])
+			} else {
+				r.values[i] = v
+			}
+		}
+	}
+	return r
+}
+
+// 为没有id的行分配id,从表中最大的id开始递增,返回行下标到id的映射。
+// 在按行标记过滤之前对所有行分配,同一行在不同的-tags下得到相同的id
+func (w *Walker) assignIds(table *Table, rows [][]string) map[int]int64 {
+	var max int64
+	var missing []int
+	for i, row := range rows {
+		if row[table.idIndex] == "" {
+			if table.hasData(row) {
+				missing = append(missing, i)
+			}
+		} else if v, err := baseParser(table.fields[table.idIndex].parser).Parse(row[table.idIndex]); err == nil {
+			if id, ok := v.value.(int64); ok && id > max {
+				max = id
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if baseParser(table.fields[table.idIndex].parser).ValueType() != typeInt {
+		w.report.Errorf(filePos(table.file), "autoid requires int id")
+		return nil
+	}
+	ids := map[int]int64{}
+	for _, i := range missing {
+		max++
+		ids[i] = max
+	}
+	return ids
+}
+
+// 解析所有数据行,出错的单元格通过report报告
+func (w *Walker) parseRows(table *Table, rows [][]string) {
+	var autoIds map[int]int64
+	if w.autoId {
+		autoIds = w.assignIds(table, rows)
+	}
+	ids := map[interface{}]int{}
+	for rowNum, row := range rows {
+		if r := w.parseDataRow(table, rowNum+DatasRow+1, row, ids); r != nil {
+			if id, ok := autoIds[rowNum]; ok && r.id == "" {
+				r.id = strconv.FormatInt(id, 10)
+				r.values[table.idIndex] = &Value{valueType: typeInt, value: id}
+			}
+			table.rows = append(table.rows, r)
+		}
+	}
+}
+
+// 解析一个数据行,被过滤或跳过的行返回nil。ids记录已出现的id及其行号,用于检查重复
+func (w *Walker) parseDataRow(table *Table, line int, row []string, ids map[interface{}]int) *Row {
+	if table.tagsIndex >= 0 && !matchTags(w.tags, splitTags(row[table.tagsIndex])) {
+		//行标记不满足-tags表达式
+		return nil
+	}
+	if row[table.idIndex] == "" {
+		if !table.hasData(row) {
+			return nil
+		} else if !w.autoId {
+			w.report.Warnf(cellPos(table.file, line, table.idIndex), "row has data but no id, skipped")
+			return nil
+		}
+	}
+
+	r := w.parseRow(table, line, row)
+	if r.id != "" && r.values[table.idIndex] != nil {
+		//使用原始值,转换成float64时超过2^53的int会相等
+		key := r.values[table.idIndex].value
+		if first, ok := ids[key]; ok {
+			w.report.Errorf(cellPos(table.file, line, table.idIndex), "duplicate id:%s first defined at row %d", r.id, first)
+		} else {
+			ids[key] = line
+		}
+	}
+	return r
+}
+
+// 返回loadPath下所有xlsx文件,按路径排序,覆盖表目录除外
+func (w *Walker) files() []string {
+	var files []string
+	overlays := filepath.Join(w.loadPath, OverlaysDir)
+	if err := filepath.Walk(w.loadPath, func(filePath string, f os.FileInfo, _ error) error {
+		if f != nil && f.IsDir() && filepath.Clean(filePath) == overlays {
+			//覆盖表单独加载
+			return filepath.SkipDir
+		} else if f != nil && !f.IsDir() && strings.Contains(f.Name(), ".xlsx") {
+			files = append(files, filePath)
+		}
+		return nil
+	}); err != nil {
+		panic(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// 用最多w.jobs个goroutine对0到n-1调用fn
+func (w *Walker) parallel(n int, fn func(i int)) {
+	jobs := w.jobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+	ch := make(chan int)
+	var wait sync.WaitGroup
+	for j := 0; j < jobs && j < n; j++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for i := range ch {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		ch <- i
+	}
+	close(ch)
+	wait.Wait()
+}
+
+// 加载并解析所有表,结果按文件路径排序
+func (w *Walker) load() []*Table {
+	files := w.files()
+	results := make([]*Table, len(files))
+	w.parallel(len(files), func(i int) {
+		start := time.Now()
+		if table, rows := w.loadTable(files[i]); table != nil {
+			w.parseRows(table, rows)
+			table.elapsed = time.Since(start)
+			results[i] = table
+		}
+	})
+	var tables []*Table
+	for _, v := range results {
+		if v != nil {
+			tables = append(tables, v)
+		}
+	}
+	return tables
+}
+
+// 输出每个表的行数和耗时
+func (w *Walker) summary(tables []*Table, start time.Time) {
+	rows := 0
+	for _, t := range tables {
+		n := len(t.rows)
+		if w.stream {
+			n = t.count
+		}
+		rows += n
+		log.Printf("%s rows:%d time:%v\n", t.file, n, t.elapsed.Round(time.Millisecond))
+	}
+	log.Printf("tables:%d rows:%d time:%v\n", len(tables), rows, time.Since(start).Round(time.Millisecond))
+}
+
+func (w *Walker) walk() {
+	start := time.Now()
+	if w.stream {
+		w.summary(w.walkStream(), start)
+		return
+	}
+	tables := w.load()
+	defer w.summary(tables, start)
+	if w.overlay != "" && w.report.Errors() == 0 {
+		w.applyOverlays(tables)
+	}
+	if w.report.Errors() == 0 {
+		w.checkRefs(tables)
+		w.checkConsts(tables)
+	}
+	if w.rules != nil && w.report.Errors() == 0 {
+		w.rules.validate(tables, w.report)
+	}
+	if w.i18n != nil && w.report.Errors() == 0 {
+		w.i18n.collect(tables)
+		w.i18n.merge(w.report, w.funcOutput == nil)
+	}
+	if w.report.Errors() > 0 || w.funcOutput == nil {
+		//有错误或仅做检查时不输出任何文件
+		return
+	}
+
+	files := make([]string, len(tables))
+	w.parallel(len(tables), func(i int) {
+		start := time.Now()
+		files[i] = w.funcOutput(w.tmpl, w.writePath, tables[i])
+		tables[i].elapsed += time.Since(start)
+	})
+	for _, v := range files {
+		if v != "" {
+			log.Printf("%s Write ok\n", v)
+		}
+	}
+	if w.funcOk != nil {
+		w.funcOk(w.writePath)
+	}
+	if w.i18n != nil {
+		w.i18n.output(w.writePath)
+	}
+}
+
+func main() {
+	input := flag.String("input", "./excel", "path of xlsx")
+	output := flag.String("output", "./lua", "path of output files")
+	gopackage := flag.String("package", "json", "package of go")
+	mode := flag.String("mode", "json", "lua|json|go|check")
+	serverOnly := flag.String("server", "false", "true|false")
+	tags := flag.String("tags", "", "expression of column tags to export, e.g. server && !debug")
+	autoId := flag.String("autoid", "false", "true|false, assign ids to rows without id")
+	assetRoot := flag.String("assetroot", "", "root path of assets, check asset columns if set")
+	assetExts := flag.String("assetext", "", "extensions of assets, e.g. .png,.prefab")
+	overlay := flag.String("overlay", "", "name of overlay, merge xlsx in input/overlays/name into base tables")
+	i18nLangs := flag.String("i18n", "", "languages of i18n columns, the first is the language in xlsx, e.g. zh,en,ja")
+	i18nDir := flag.String("i18ndir", "./i18n", "path of translation files")
+	i18nFormat := flag.String("i18nfmt", "json", "json|csv, format of translation files")
+	locale := flag.String("locale", "", "locales of name@locale columns to export, e.g. zh,en. export all locales as an object if empty")
+	fillMerged := flag.String("fillmerged", "false", "true|false, fill merged cells with the top-left value")
+	skipHidden := flag.String("skiphidden", "false", "true|false, skip hidden rows, columns and sheets")
+	rulesFile := flag.String("rules", "", "path of cross-table validation rules(json)")
+	stream := flag.String("stream", "false", "true|false, read and write rows one by one for very large tables")
+	goData := flag.String("godata", "file", "file|literal|embed, load tables from json files, generate table data as go code or embed json files in go mode")
+	goOrder := flag.String("goorder", "id", "id|row, order of All<Table>() and ForEach<Table>() in go mode")
+	goGet := flag.String("goget", "map", "map|binary, Get<Table>() looks up a map or binary searches the sorted rows in go mode")
+	goReadonly := flag.String("goreadonly", "false", "true|false, generate unexported fields with getters in go mode")
+	goRuntime := flag.String("goruntime", "false", "true|false, generate code using the runtime package github.com/sniperHW/tabgo/table in go mode")
+	goWatch := flag.String("gowatch", "false", "true|false, generate Watch(dir, onReload) to reload changed json files in go mode")
+	jobs := flag.Int("jobs", 0, "number of tables processed at the same time, number of cpus if <= 0")
+	flag.Parse()
+
+	var fn func(tmpl *template.Template, writePath string, tab *Table) string
+	var fnStream func(tmpl *template.Template, writePath string, tab *Table) *streamWriter
+	var walkOk func(writePath string)
+	var names func(tables []*Table) map[string]bool
+	var tmpl *template.Template
+	var err error
+
+	switch *mode {
+	case "lua":
+		fn = outputLua
+		fnStream = streamLua
+		tmpl, err = template.New("test").Parse(luaTemplate)
+		if err != nil {
+			panic(err)
+		}
+	case "json":
+		fn = outputJson
+		fnStream = streamJson
+		tmpl, err = template.New("test").Parse(jsonTemplate)
+		if err != nil {
+			panic(err)
+		}
+	case "go":
+		if *goData != "file" && *goData != "literal" && *goData != "embed" {
+			panic("unsupport godata")
+		}
+		if *goOrder != "id" && *goOrder != "row" {
+			panic("unsupport goorder")
+		}
+		if *goGet == "binary" && *goOrder != "id" {
+			panic("goget=binary requires goorder=id")
+		}
+		g := &goGenerator{Package: *gopackage, data: *goData, order: *goOrder, binary: *goGet == "binary", readonly: *goReadonly == "true", runtime: *goRuntime == "true", Watch: *goWatch == "true"}
+		fn = g.outputGo
+		walkOk = g.walkOk
+		names = g.names
+		text := goTemplate
+		if g.runtime {
+			text = goRuntimeTemplate
+		}
+		tmpl, err = template.New("test").Parse(text)
+		if err != nil {
+			panic(err)
+		}
+	case "check":
+		//只加载,解析,校验所有表,不输出文件
+	default:
+		panic("unsupport mode")
+	}
+
+	w := &Walker{
+		loadPath:   *input,
+		writePath:  *output,
+		tmpl:       tmpl,
+		funcOutput: fn,
+		funcOk:     walkOk,
+		funcNames:  names,
+		stream:     *stream == "true",
+		jobs:       *jobs,
+		report:     &Reporter{},
+		autoId:     *autoId == "true",
+		overlay:    *overlay,
+		fillMerged: *fillMerged == "true",
+		skipHidden: *skipHidden == "true",
+	}
+
+	if w.stream {
+		if w.autoId || w.fillMerged || *overlay != "" || *i18nLangs != "" || *rulesFile != "" || *goData != "file" {
+			panic("stream can not be used with autoid, fillmerged, overlay, i18n, rules or godata")
+		}
+		w.funcStream = fnStream
+	}
+
+	setAssetConfig(*assetRoot, *assetExts)
+
+	if langs := splitTags(*i18nLangs); len(langs) > 0 {
+		if *i18nFormat != "json" && *i18nFormat != "csv" {
+			panic("unsupport i18n format")
+		}
+		w.i18n = &I18n{
+			langs:  langs,
+			dir:    *i18nDir,
+			format: *i18nFormat,
+			mode:   *mode,
+			pkg:    *gopackage,
+		}
+	}
+
+	if *rulesFile != "" {
+		if w.rules, err = loadRules(*rulesFile); err != nil {
+			panic(err)
+		}
+	}
+
+	selection := *tags
+	if *serverOnly == "true" {
+		//打服务端表，忽略所有标记为client的字段
+		if selection == "" {
+			selection = "!client"
+		} else {
+			selection = "(" + selection + ") && !client"
+		}
+	}
+
+	if selection != "" {
+		if w.tags, err = parseTags(selection); err != nil {
+			panic(err)
+		}
+	}
+
+	if locales := splitTags(*locale); len(locales) > 1 {
+		//每种语言输出到单独的目录
+		for _, v := range locales {
+			w.locale = v
+			w.writePath = filepath.Join(*output, v)
+			if w.walk(); w.report.Errors() > 0 {
+				break
+			}
+		}
+	} else {
+		if len(locales) == 1 {
+			w.locale = locales[0]
+		}
+		w.walk()
+	}
+	w.report.Flush()
+	if n := w.report.Errors(); n > 0 {
+		log.Printf("%d errors\n", n)
+		os.Exit(1)
+	}
+}
@@ -1,10 +1,21 @@
 package main
 
 import (
+	"archive/zip"
 	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"text/template"
+	"time"
 
+	"github.com/360EntSecGroup-Skylar/excelize"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -191,3 +202,1653 @@ func TestParse(t *testing.T) {
 	}
 
 }
+
+// 在dir下生成name.xlsx,rows依次写入Sheet1
+func writeXlsx(t *testing.T, dir string, name string, rows [][]string) {
+	xlsx := excelize.NewFile()
+	for i, row := range rows {
+		for j, v := range row {
+			xlsx.SetCellStr("Sheet1", fmt.Sprintf("%s%d", excelize.ToAlphaString(j), i+1), v)
+		}
+	}
+	assert.Nil(t, xlsx.SaveAs(filepath.Join(dir, name+".xlsx")))
+}
+
+// 重命名xlsx中的文件并修改所有引用它的地方
+func renamePart(t *testing.T, file string, from string, to string) {
+	r, err := zip.OpenReader(file)
+	assert.Nil(t, err)
+	parts := map[string][]byte{}
+	var names []string
+	for _, f := range r.File {
+		rc, _ := f.Open()
+		b := new(strings.Builder)
+		_, err = io.Copy(b, rc)
+		rc.Close()
+		assert.Nil(t, err)
+		name := strings.Replace(f.Name, from, to, 1)
+		parts[name] = []byte(strings.ReplaceAll(b.String(), from, to))
+		names = append(names, name)
+	}
+	r.Close()
+	out, err := os.Create(file)
+	assert.Nil(t, err)
+	zw := zip.NewWriter(out)
+	for _, name := range names {
+		fw, _ := zw.Create(name)
+		fw.Write(parts[name])
+	}
+	assert.Nil(t, zw.Close())
+	out.Close()
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "count", "pos"},
+		{"int", "int", "{x:int,y:int}"},
+		{"物品id", "数量", "坐标"},
+		{"1", "10", "{x:1,y:2}"},
+		{"2", "abc", "{x:1,y:2}"},
+		{"3", "10", "{x:1,z:2}"},
+	})
+
+	output := filepath.Join(dir, "output")
+	w := &Walker{
+		loadPath:  dir,
+		writePath: output,
+		report:    &Reporter{},
+	}
+	w.walk()
+	assert.Equal(t, 2, w.report.Errors())
+	assert.Equal(t, "Item.xlsx!B5", w.report.diags[0].pos.String())
+	w.report.Flush()
+
+	_, err := os.Stat(output)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestConstraint(t *testing.T) {
+	{
+		p, err := MakeParser("int(1..100)")
+		assert.Nil(t, err)
+		_, err = p.Parse("50")
+		assert.Nil(t, err)
+		_, err = p.Parse("101")
+		assert.NotNil(t, err)
+		_, err = p.Parse("")
+		assert.Nil(t, err)
+	}
+
+	{
+		p, err := MakeParser("string!")
+		assert.Nil(t, err)
+		_, err = p.Parse("")
+		assert.NotNil(t, err)
+	}
+
+	{
+		p, err := MakeParser("int unique")
+		assert.Nil(t, err)
+		_, err = p.Parse("1")
+		assert.Nil(t, err)
+		_, err = p.Parse("1")
+		assert.NotNil(t, err)
+	}
+
+	{
+		p, err := MakeParser("string~^icon_")
+		assert.Nil(t, err)
+		_, err = p.Parse("icon_a")
+		assert.Nil(t, err)
+		_, err = p.Parse("a")
+		assert.NotNil(t, err)
+	}
+
+	{
+		_, err := MakeParser("int~^a")
+		assert.NotNil(t, err)
+		_, err = MakeParser("{x:int}(1..2)")
+		assert.NotNil(t, err)
+	}
+
+	{
+		//数组元素约束和数组长度约束
+		p, err := MakeParser("int(1..3)[](..2)")
+		assert.Nil(t, err)
+		_, err = p.Parse("[1,3]")
+		assert.Nil(t, err)
+		_, err = p.Parse("[1,4]")
+		assert.NotNil(t, err)
+		_, err = p.Parse("[1,2,3]")
+		assert.NotNil(t, err)
+	}
+
+	{
+		//结构体成员约束
+		p, err := MakeParser("{x:int(1..10),y:string!,z:string~^a}[]")
+		assert.Nil(t, err)
+		_, err = p.Parse(`[{x:1,y:"b",z:"a"}]`)
+		assert.Nil(t, err)
+		_, err = p.Parse(`[{x:11,y:"b"}]`)
+		assert.NotNil(t, err)
+		_, err = p.Parse(`[{x:1}]`)
+		assert.NotNil(t, err)
+		_, err = p.Parse(`[{x:1,y:"b",z:"b"}]`)
+		assert.NotNil(t, err)
+	}
+
+	{
+		p, err := MakeParser("string~^icon_[0-9]+$")
+		assert.Nil(t, err)
+		sb := strings.Builder{}
+		p.GenGoStruct(&sb, "f")
+		assert.Equal(t, "string", p.GetGoType())
+	}
+}
+
+func TestExpr(t *testing.T) {
+	env := &ruleEnv{}
+	for s, want := range map[string]interface{}{
+		"1 + 2 * 3":            7.0,
+		"(1 + 2) * 3":          9.0,
+		"-2 < 1 && !(1 == 2)":  true,
+		"'a' + \"b\" == 'ab'":  true,
+		"1 > 2 || 3 % 2 == 1":  true,
+		"10 / 4 >= 2.5":        true,
+		"false && unknown > 1": false,
+	} {
+		e, err := parseExpr(s)
+		assert.Nil(t, err, s)
+		v, err := e.eval(env)
+		assert.Nil(t, err, s)
+		assert.Equal(t, want, v, s)
+	}
+
+	for _, s := range []string{"1 +", "(1", "a b", "f(1,", "1 # 2"} {
+		_, err := parseExpr(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestRules(t *testing.T) {
+	dir := t.TempDir()
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "name"},
+		{"int", "string"},
+		{"", ""},
+		{"1", "a"},
+		{"2", "b"},
+	})
+	writeXlsx(t, dir, "Shop", [][]string{
+		{"id", "itemId", "items"},
+		{"int", "int", "int[]"},
+		{"", "", ""},
+		{"1", "1", "[1,2]"},
+		{"2", "3", "[1,3]"},
+	})
+	writeXlsx(t, dir, "Skill", [][]string{
+		{"id", "skillId", "level", "weight"},
+		{"int", "int", "int", "int"},
+		{"", "", "", ""},
+		{"1", "1", "1", "5000"},
+		{"2", "1", "2", "5000"},
+		{"3", "2", "1", "5000"},
+		{"4", "2", "3", "4000"},
+	})
+	rulesFile := filepath.Join(dir, "rules.json")
+	os.WriteFile(rulesFile, []byte(`{"rules":[
+		{"name":"item","table":"Shop","check":"exists(Item, itemId)"},
+		{"name":"items","table":"Shop","check":"exists(Item.id, items)"},
+		{"name":"level","table":"Skill","group":"skillId","check":"contiguous(level, 1)"},
+		{"name":"weight","table":"Skill","group":"skillId","check":"sum(weight) == 10000"},
+		{"name":"where","table":"Skill","where":"skillId == 1","check":"level <= 2"}
+	]}`), 0644)
+
+	rules, err := loadRules(rulesFile)
+	assert.Nil(t, err)
+
+	w := &Walker{
+		loadPath: dir,
+		report:   &Reporter{},
+		rules:    rules,
+	}
+	w.walk()
+	assert.Equal(t, 4, w.report.Errors())
+	var diags []string
+	for _, d := range w.report.diags {
+		diags = append(diags, d.String())
+	}
+	sort.Strings(diags)
+	//Shop第2行的itemId和items引用了不存在的3,Skill的第2组等级不连续且权重不足,where过滤后的规则通过
+	assert.Equal(t, []string{
+		"[error] Shop.xlsx!B5 rule item failed: exists(Item, itemId)",
+		"[error] Shop.xlsx!C5 rule items failed: exists(Item.id, items)",
+		"[error] Skill.xlsx!6 rule level failed: contiguous(level, 1) group:(2) rows:(6,7)",
+		"[error] Skill.xlsx!6 rule weight failed: sum(weight) == 10000 group:(2) rows:(6,7)",
+	}, diags)
+
+	//超过2^53的id按原始值比较,ref和exists都能发现不存在的id
+	dir = t.TempDir()
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "name"},
+		{"int", "string"},
+		{"", ""},
+		{"9007199254740992", "a"},
+	})
+	writeXlsx(t, dir, "Shop", [][]string{
+		{"id", "itemId:ref=Item"},
+		{"int", "int"},
+		{"", ""},
+		{"1", "9007199254740993"},
+	})
+	w = &Walker{
+		loadPath: dir,
+		report:   &Reporter{},
+	}
+	w.walk()
+	assert.Equal(t, 1, w.report.Errors())
+	assert.Equal(t, "[error] Shop.xlsx!B4 ref: 9007199254740993 not found in Item", w.report.diags[0].String())
+
+	writeXlsx(t, dir, "Shop", [][]string{
+		{"id", "itemId", "items"},
+		{"int", "int", "int[]"},
+		{"", "", ""},
+		{"1", "9007199254740993", "[9007199254740992]"},
+		{"2", "9007199254740992", "[9007199254740993]"},
+	})
+	os.WriteFile(rulesFile, []byte(`{"rules":[
+		{"name":"item","table":"Shop","check":"exists(Item, itemId)"},
+		{"name":"items","table":"Shop","check":"exists(Item.id, items)"}
+	]}`), 0644)
+	rules, err = loadRules(rulesFile)
+	assert.Nil(t, err)
+	w = &Walker{
+		loadPath: dir,
+		report:   &Reporter{},
+		rules:    rules,
+	}
+	w.walk()
+	diags = nil
+	for _, d := range w.report.diags {
+		diags = append(diags, d.String())
+	}
+	sort.Strings(diags)
+	assert.Equal(t, []string{
+		"[error] Shop.xlsx!B4 rule item failed: exists(Item, itemId)",
+		"[error] Shop.xlsx!C5 rule items failed: exists(Item.id, items)",
+	}, diags)
+}
+
+func TestId(t *testing.T) {
+	dir := t.TempDir()
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "name"},
+		{"int", "string"},
+		{"", ""},
+		{"1", "a"},
+		{"", "b"},
+		{"1", "c"},
+		{"", ""},
+		{"5", "d"},
+	})
+
+	w := &Walker{
+		loadPath: dir,
+		report:   &Reporter{},
+	}
+	tables := w.load()
+	assert.Equal(t, 1, w.report.Errors())
+	assert.Equal(t, 2, len(w.report.diags))
+	assert.Equal(t, 3, len(tables[0].rows))
+
+	dir = t.TempDir()
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "name"},
+		{"int", "string"},
+		{"", ""},
+		{"1", "a"},
+		{"", "b"},
+		{"5", "d"},
+	})
+	w = &Walker{
+		loadPath: dir,
+		report:   &Reporter{},
+		autoId:   true,
+	}
+	tables = w.load()
+	assert.Equal(t, 0, len(w.report.diags))
+	assert.Equal(t, "6", tables[0].rows[1].id)
+
+	//先分配id再按行标记过滤,不同的-tags下同一行的id相同
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "name", "#tags"},
+		{"int", "string", ""},
+		{"", "", ""},
+		{"1", "a", ""},
+		{"", "b", "test"},
+		{"", "c", ""},
+	})
+	ids := map[string]string{}
+	for _, tags := range []string{"", "!test"} {
+		w = &Walker{
+			loadPath: dir,
+			report:   &Reporter{},
+			autoId:   true,
+		}
+		w.tags, _ = parseTags(tags)
+		tables = w.load()
+		assert.Equal(t, 0, w.report.Errors())
+		for _, r := range tables[0].rows {
+			if id, ok := ids[r.values[1].value.(string)]; ok {
+				assert.Equal(t, id, r.id, tags)
+			}
+			ids[r.values[1].value.(string)] = r.id
+		}
+	}
+	assert.Equal(t, map[string]string{"a": "1", "b": "2", "c": "3"}, ids)
+
+	//超过2^53的id不重复
+	dir = t.TempDir()
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "name"},
+		{"int", "string"},
+		{"", ""},
+		{"9007199254740992", "a"},
+		{"9007199254740993", "b"},
+	})
+	w = &Walker{
+		loadPath: dir,
+		report:   &Reporter{},
+	}
+	tables = w.load()
+	assert.Equal(t, 0, w.report.Errors())
+	assert.Equal(t, 2, len(tables[0].rows))
+}
+
+func TestAsset(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "icon"), os.ModePerm)
+	os.WriteFile(filepath.Join(dir, "icon", "a.png"), []byte{}, 0644)
+	setAssetConfig(dir, "png,.prefab")
+	defer setAssetConfig("", "")
+
+	p, err := MakeParser("asset[]")
+	assert.Nil(t, err)
+	_, err = p.Parse(`["icon/a","icon/a.png"]`)
+	assert.Nil(t, err)
+	_, err = p.Parse(`["icon/b"]`)
+	assert.NotNil(t, err)
+
+	p, _ = MakeParser("asset")
+	_, err = p.Parse("")
+	assert.Nil(t, err)
+	assert.Equal(t, "string", p.GetGoType())
+
+	//修改配置后不使用之前的检查结果
+	dir2 := t.TempDir()
+	os.MkdirAll(filepath.Join(dir2, "icon"), os.ModePerm)
+	os.WriteFile(filepath.Join(dir2, "icon", "b.png"), []byte{}, 0644)
+	setAssetConfig(dir2, "png")
+	_, err = p.Parse("icon/b")
+	assert.Nil(t, err)
+	_, err = p.Parse("icon/a")
+	assert.NotNil(t, err)
+}
+
+func TestTags(t *testing.T) {
+	w := &Walker{}
+	check := func(s string) bool {
+		_, _, _, ok := w.checkColumn(s)
+		return ok
+	}
+
+	assert.True(t, check("hp:client"))
+	assert.False(t, check("hp:annotation"))
+	assert.False(t, check(":client"))
+
+	w.tags, _ = parseTags("server && !debug")
+	assert.True(t, check("hp"))
+	assert.True(t, check("hp:server"))
+	assert.True(t, check("hp:server,gm"))
+	assert.False(t, check("hp:client"))
+	assert.False(t, check("hp:server, debug"))
+
+	name, tags, _, _ := w.checkColumn("hp:server,gm")
+	assert.Equal(t, "hp", name)
+	assert.Equal(t, []string{"server", "gm"}, tags)
+
+	_, err := parseTags("server +")
+	assert.NotNil(t, err)
+	_, err = parseTags("1")
+	assert.NotNil(t, err)
+}
+
+func TestRowTags(t *testing.T) {
+	dir := t.TempDir()
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "name", "#tags"},
+		{"int", "string", ""},
+		{"", "", ""},
+		{"1", "a", ""},
+		{"2", "b", "test"},
+		{"2", "c", "release"},
+	})
+
+	w := &Walker{
+		loadPath: dir,
+		report:   &Reporter{},
+	}
+	w.tags, _ = parseTags("!test")
+	tables := w.load()
+	assert.Equal(t, 0, w.report.Errors())
+	assert.Equal(t, 2, len(tables[0].rows))
+	assert.Equal(t, 6, tables[0].rows[1].line)
+
+	b := strings.Builder{}
+	for _, v := range tables[0].rows[1].values {
+		if v != nil {
+			v.ToJsonString(&b)
+		}
+	}
+	assert.Equal(t, `2"c"`, b.String())
+}
+
+func TestOverlay(t *testing.T) {
+	dir := t.TempDir()
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "name", "price", "icon:client"},
+		{"int", "string", "int", "string"},
+		{"", "", "", ""},
+		{"1", "a", "10", "i1"},
+		{"2", "b", "20", "i2"},
+	})
+	os.MkdirAll(filepath.Join(dir, OverlaysDir, "cn"), os.ModePerm)
+	writeXlsx(t, filepath.Join(dir, OverlaysDir, "cn"), "Item", [][]string{
+		{"id", "price", "icon"},
+		{"", "", ""},
+		{"", "", ""},
+		{"2", "25", "x"},
+		{"1", "", ""},
+	})
+
+	w := &Walker{
+		loadPath: dir,
+		report:   &Reporter{},
+		overlay:  "cn",
+	}
+	w.tags, _ = parseTags("!client")
+	tables := w.load()
+	assert.Equal(t, 1, len(tables))
+	w.applyOverlays(tables)
+	//icon被-tags排除,不能覆盖
+	assert.Equal(t, 1, w.report.Errors())
+	assert.Equal(t, int64(10), tables[0].rows[0].values[2].value)
+	assert.Equal(t, int64(25), tables[0].rows[1].values[2].value)
+
+
+	writeXlsx(t, filepath.Join(dir, OverlaysDir, "cn"), "Item", [][]string{
+		{"id", "price", "weight"},
+		{"", "", ""},
+		{"", "", ""},
+		{"3", "25", "1"},
+	})
+	w.report = &Reporter{}
+	tables = w.load()
+	w.applyOverlays(tables)
+	assert.Equal(t, 2, w.report.Errors())
+	w.report.Flush()
+
+	//覆盖的值检查基础表的约束,unique除外
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "name", "price", "tags"},
+		{"int", "string~^[a-z]+$", "int(1..100) unique", "int[]!"},
+		{"", "", "", ""},
+		{"1", "a", "10", "[1]"},
+		{"2", "b", "20", "[2]"},
+	})
+	writeXlsx(t, filepath.Join(dir, OverlaysDir, "cn"), "Item", [][]string{
+		{"id", "name", "price", "tags"},
+		{"", "", "", ""},
+		{"", "", "", ""},
+		{"1", "X", "200", "[]"},
+		{"2", "c", "10", ""},
+	})
+	w.report = &Reporter{}
+	tables = w.load()
+	w.applyOverlays(tables)
+	assert.Equal(t, 3, w.report.Errors())
+	assert.Equal(t, int64(10), tables[0].rows[1].values[2].value)
+
+	//超过2^53的id覆盖正确的行
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "name"},
+		{"int", "string"},
+		{"", ""},
+		{"9007199254740992", "a"},
+		{"9007199254740993", "b"},
+	})
+	writeXlsx(t, filepath.Join(dir, OverlaysDir, "cn"), "Item", [][]string{
+		{"id", "name"},
+		{"", ""},
+		{"", ""},
+		{"9007199254740992", "c"},
+	})
+	w.report = &Reporter{}
+	tables = w.load()
+	w.applyOverlays(tables)
+	assert.Equal(t, 0, w.report.Errors())
+	assert.Equal(t, "c", tables[0].rows[0].values[1].value)
+	assert.Equal(t, "b", tables[0].rows[1].values[1].value)
+
+	//同名的列覆盖没有被排除的列
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "name:client", "name:server"},
+		{"int", "string", "string"},
+		{"", "", ""},
+		{"1", "a", "b"},
+	})
+	writeXlsx(t, filepath.Join(dir, OverlaysDir, "cn"), "Item", [][]string{
+		{"id", "name"},
+		{"", ""},
+		{"", ""},
+		{"1", "c"},
+	})
+	w.report = &Reporter{}
+	w.tags, _ = parseTags("server")
+	tables = w.load()
+	w.applyOverlays(tables)
+	assert.Equal(t, 0, w.report.Errors())
+	assert.Equal(t, "c", tables[0].rows[0].values[2].value)
+}
+
+func TestI18n(t *testing.T) {
+	dir := t.TempDir()
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "name", "tips"},
+		{"int", "i18n", "{title:i18n,level:int}[]"},
+		{"", "", ""},
+		{"1", "苹果", `[{title:"红色",level:1}]`},
+		{"2", "", ""},
+	})
+
+	i18nDir := filepath.Join(dir, "i18n")
+	os.MkdirAll(i18nDir, os.ModePerm)
+	os.WriteFile(filepath.Join(i18nDir, "en.csv"), []byte("key,source,text\nItem.1.name,苹果,apple\n"), 0644)
+
+	w := &Walker{
+		loadPath:   dir,
+		writePath:  filepath.Join(dir, "output"),
+		report:     &Reporter{},
+		funcOutput: outputJson,
+		i18n: &I18n{
+			langs:  []string{"zh", "en"},
+			dir:    i18nDir,
+			format: "csv",
+			mode:   "json",
+		},
+	}
+	w.tmpl, _ = template.New("test").Parse(jsonTemplate)
+	w.walk()
+	assert.Equal(t, 0, w.report.Errors())
+	assert.Equal(t, 1, len(w.report.diags))
+	w.report.Flush()
+
+	b, _ := os.ReadFile(filepath.Join(dir, "output", "Item.json"))
+	assert.Contains(t, string(b), `"name":"Item.1.name","tips":[{"title":"Item.1.tips.0.title","level":1}]`)
+	assert.Contains(t, string(b), `"name":""`)
+
+	b, _ = os.ReadFile(filepath.Join(i18nDir, "en.csv"))
+	assert.Equal(t, "key,source,text\nItem.1.name,苹果,apple\nItem.1.tips.0.title,红色,\n", string(b))
+
+	b, _ = os.ReadFile(filepath.Join(dir, "output", "i18n_en.json"))
+	assert.Contains(t, string(b), `"Item.1.name": "apple"`)
+	assert.Contains(t, string(b), `"Item.1.tips.0.title": "红色"`)
+
+	//-mode go生成的I18n可以读取同时生成的翻译
+	g := &goGenerator{Package: "main", data: "literal"}
+	w = newGoWalker(dir, filepath.Join(dir, "output"), g)
+	w.i18n = &I18n{
+		langs:  []string{"zh", "en"},
+		dir:    i18nDir,
+		format: "csv",
+		mode:   "go",
+		pkg:    "main",
+	}
+	w.walk()
+	assert.Equal(t, 0, w.report.Errors())
+	pkg := filepath.Join(dir, "output", "main")
+	out := goRun(t, pkg, `
+	if err := LoadI18nFromFile("`+filepath.ToSlash(filepath.Join(pkg, "i18n_en.json"))+`"); err != nil {
+		panic(err)
+	}
+	m, _ := GetItem(1)
+	fmt.Println(I18n(m.Name), I18n(m.Tips[0].Title))`)
+	assert.Equal(t, "apple 红色\n", out)
+}
+
+func TestLocale(t *testing.T) {
+	dir := t.TempDir()
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "name@zh", "count", "name@en"},
+		{"int", "string", "int", "string"},
+		{"", "", "", ""},
+		{"1", "苹果", "1", "apple"},
+		{"2", "香蕉", "2", ""},
+	})
+
+	json := func(table *Table, row int) string {
+		b := strings.Builder{}
+		for i, v := range table.rows[row].values {
+			if v != nil {
+				b.WriteString(table.fields[i].name + "=")
+				v.ToJsonString(&b)
+				b.WriteString(";")
+			}
+		}
+		return b.String()
+	}
+
+	w := &Walker{
+		loadPath: dir,
+		report:   &Reporter{},
+	}
+	tables := w.load()
+	assert.Equal(t, 0, w.report.Errors())
+	assert.Equal(t, 1, len(w.report.diags))
+	assert.Equal(t, `id=1;name={"zh":"苹果","en":"apple"};count=1;`, json(tables[0], 0))
+	assert.Equal(t, `id=2;name={"zh":"香蕉","en":""};count=2;`, json(tables[0], 1))
+
+	w = &Walker{
+		loadPath: dir,
+		report:   &Reporter{},
+		locale:   "en",
+	}
+	tables = w.load()
+	assert.Equal(t, `id=1;name="apple";count=1;`, json(tables[0], 0))
+
+	w.locale = ""
+	tables = w.load()
+	tmpl, _ := template.New("test").Parse(goTemplate)
+	g := &goGenerator{Package: "conf"}
+	b, _ := os.ReadFile(g.outputGo(tmpl, dir, tables[0]))
+	assert.Contains(t, string(b), "type ItemName struct")
+
+	w.locale = "ja"
+	w.load()
+	assert.Equal(t, 1, w.report.Errors())
+
+	//空的语言列检查!约束,合并的列不能有列属性
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "name@zh", "name@en"},
+		{"int", "string!", "string!"},
+		{"", "", ""},
+		{"1", "苹果", ""},
+	})
+	w = &Walker{
+		loadPath: dir,
+		report:   &Reporter{},
+	}
+	w.load()
+	assert.Equal(t, 1, w.report.Errors())
+	assert.Equal(t, 2, len(w.report.diags))
+
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "title@zh:index", "title@en"},
+		{"int", "string", "string"},
+		{"", "", ""},
+		{"1", "a", "a"},
+	})
+	w = &Walker{
+		loadPath: dir,
+		report:   &Reporter{},
+	}
+	w.load()
+	assert.Equal(t, 1, w.report.Errors())
+}
+
+func TestFormula(t *testing.T) {
+	dir := t.TempDir()
+	xlsx := excelize.NewFile()
+	for i, row := range [][]interface{}{
+		{"id", "atk", "speed", "dps", "desc", "total"},
+		{"int", "int", "float", "float", "string", "float"},
+		{"", "", "", "", "", ""},
+		{1, 10, 1.5, nil, nil, nil},
+		{2, 20, 2, nil, nil, nil},
+	} {
+		for j, v := range row {
+			if v != nil {
+				xlsx.SetCellValue("Sheet1", fmt.Sprintf("%s%d", excelize.ToAlphaString(j), i+1), v)
+			}
+		}
+	}
+	xlsx.SetCellFormula("Sheet1", "D4", "B4*C4")
+	xlsx.SetCellFormula("Sheet1", "D5", "IF(B5>10,ROUND(B5*C5/3,2),0)")
+	xlsx.SetCellFormula("Sheet1", "E4", `CONCATENATE("atk:",B4)`)
+	xlsx.SetCellFormula("Sheet1", "F4", "SUM(D4:D5)+$B$4%")
+	assert.Nil(t, xlsx.SaveAs(filepath.Join(dir, "Hero.xlsx")))
+
+	w := &Walker{
+		loadPath: dir,
+		report:   &Reporter{},
+	}
+	tables := w.load()
+	assert.Equal(t, 0, w.report.Errors())
+	assert.Equal(t, 15.0, tables[0].rows[0].values[3].value)
+	assert.Equal(t, 13.33, tables[0].rows[1].values[3].value)
+	assert.Equal(t, "atk:10", tables[0].rows[0].values[4].value)
+	assert.Equal(t, "", tables[0].rows[1].values[4].value)
+	assert.Equal(t, 28.43, tables[0].rows[0].values[5].value)
+	w.report.Flush()
+
+	//sheet的文件名不是sheetN.xml时报告错误,而不是忽略公式
+	renamePart(t, filepath.Join(dir, "Hero.xlsx"), "worksheets/sheet1.xml", "worksheets/hero.xml")
+	w = &Walker{
+		loadPath: dir,
+		report:   &Reporter{},
+	}
+	w.load()
+	assert.Equal(t, 1, w.report.Errors())
+	assert.Nil(t, xlsx.SaveAs(filepath.Join(dir, "Hero.xlsx")))
+
+	//不支持的公式有缓存值时使用缓存值并警告,没有缓存值时报告错误
+	xlsx.SetCellFormula("Sheet1", "E5", "Sheet2!A1")
+	xlsx.SetCellValue("Sheet1", "E4", "cached")
+	xlsx.SetCellFormula("Sheet1", "E4", "VLOOKUP(1,A4:B5,2)")
+	assert.Nil(t, xlsx.SaveAs(filepath.Join(dir, "Hero.xlsx")))
+	w = &Walker{
+		loadPath: dir,
+		report:   &Reporter{},
+	}
+	tables = w.load()
+	assert.Equal(t, 1, w.report.Errors())
+	assert.Equal(t, "cached", tables[0].rows[0].values[4].value)
+
+	//共享公式
+	e := &formulaEval{
+		rows:     [][]string{{"1", "2", ""}, {"3", "4", ""}},
+		formulas: map[[2]int]*formulaCell{{0, 2}: {text: "A1+B1"}, {1, 2}: {text: "A1+B1", dr: 1}},
+		values:   map[[2]int]interface{}{},
+		visiting: map[[2]int]bool{},
+	}
+	v, err := e.cell(1, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 7.0, v)
+}
+
+func TestMergedHidden(t *testing.T) {
+	dir := t.TempDir()
+	xlsx := excelize.NewFile()
+	for i, row := range [][]string{
+		{"id", "group", "name", "memo"},
+		{"int", "int", "string", "string"},
+		{"", "", "", ""},
+		{"1", "10", "a", "x"},
+		{"2", "", "b", "y"},
+		{"3", "", "c", "z"},
+	} {
+		for j, v := range row {
+			xlsx.SetCellStr("Sheet1", fmt.Sprintf("%s%d", excelize.ToAlphaString(j), i+1), v)
+		}
+	}
+	xlsx.MergeCell("Sheet1", "B4", "B6")
+	xlsx.SetColVisible("Sheet1", "D", false)
+	xlsx.SetRowVisible("Sheet1", 4, false)
+	assert.Nil(t, xlsx.SaveAs(filepath.Join(dir, "Item.xlsx")))
+
+	w := &Walker{
+		loadPath:   dir,
+		report:     &Reporter{},
+		fillMerged: true,
+		skipHidden: true,
+	}
+	tables := w.load()
+	assert.Equal(t, 0, w.report.Errors())
+	assert.Equal(t, 2, len(tables[0].rows))
+	assert.Equal(t, int64(10), tables[0].rows[1].values[1].value)
+	assert.Nil(t, tables[0].fields[3].parser)
+
+	//当前sheet不是第一个sheet,隐藏的行在它自己的xml文件中
+	xlsx = excelize.NewFile()
+	xlsx.SetCellStr("Sheet1", "A1", "memo")
+	index := xlsx.NewSheet("Data")
+	for i, row := range [][]string{
+		{"id", "name"},
+		{"int", "string"},
+		{"", ""},
+		{"1", "a"},
+		{"2", "b"},
+	} {
+		for j, v := range row {
+			xlsx.SetCellStr("Data", fmt.Sprintf("%s%d", excelize.ToAlphaString(j), i+1), v)
+		}
+	}
+	xlsx.SetRowVisible("Data", 3, false)
+	xlsx.SetActiveSheet(index)
+	assert.Nil(t, xlsx.SaveAs(filepath.Join(dir, "Item.xlsx")))
+	tables = w.load()
+	assert.Equal(t, 0, w.report.Errors())
+	assert.Equal(t, 1, len(tables[0].rows))
+	assert.Equal(t, "b", tables[0].rows[0].values[1].value)
+}
+
+func TestStream(t *testing.T) {
+	dir := t.TempDir()
+	xlsx := excelize.NewFile()
+	for i, row := range [][]string{
+		{"id", "name", "pos", "memo"},
+		{"int", "string", "{x:int,y:int}", "string"},
+		{"", "", "", ""},
+		{"1", "a", "{x:1,y:2}", "x"},
+		{"2", "b", "", "y"},
+		{"", "", "", ""},
+		{"4", "d", "{x:3,y:4}", "z"},
+	} {
+		for j, v := range row {
+			xlsx.SetCellStr("Sheet1", fmt.Sprintf("%s%d", excelize.ToAlphaString(j), i+1), v)
+		}
+	}
+	xlsx.SetColVisible("Sheet1", "D", false)
+	xlsx.SetRowVisible("Sheet1", 4, false)
+	assert.Nil(t, xlsx.SaveAs(filepath.Join(dir, "Item.xlsx")))
+
+	tmpl, _ := template.New("test").Parse(jsonTemplate)
+	for _, stream := range []bool{false, true} {
+		w := &Walker{
+			loadPath:   dir,
+			writePath:  filepath.Join(dir, fmt.Sprint(stream)),
+			tmpl:       tmpl,
+			funcOutput: outputJson,
+			funcStream: streamJson,
+			report:     &Reporter{},
+			skipHidden: true,
+			stream:     stream,
+		}
+		w.walk()
+		assert.Equal(t, 0, w.report.Errors())
+	}
+	a, _ := os.ReadFile(filepath.Join(dir, "false", "Item.json"))
+	b, _ := os.ReadFile(filepath.Join(dir, "true", "Item.json"))
+	assert.Equal(t, string(a), string(b))
+	assert.False(t, strings.Contains(string(b), "memo"))
+	assert.False(t, strings.Contains(string(b), "\"2\""))
+
+	//重复的id不输出文件
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "name"},
+		{"int", "string"},
+		{"", ""},
+		{"1", "a"},
+		{"1", "b"},
+	})
+	w := &Walker{
+		loadPath:   dir,
+		writePath:  filepath.Join(dir, "dup"),
+		tmpl:       tmpl,
+		funcOutput: outputJson,
+		funcStream: streamJson,
+		report:     &Reporter{},
+		stream:     true,
+	}
+	w.walk()
+	assert.Equal(t, 1, w.report.Errors())
+	_, err := os.Stat(filepath.Join(dir, "dup", "Item.json"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "dup", "Item.json.tmp"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+// 生成一个大表,用于比较流式和非流式打表
+func benchTable(b *testing.B) string {
+	dir := b.TempDir()
+	xlsx := excelize.NewFile()
+	for i, v := range []string{"id", "name", "count", "pos", "items"} {
+		xlsx.SetCellStr("Sheet1", excelize.ToAlphaString(i)+"1", v)
+	}
+	for i, v := range []string{"int", "string", "int", "{x:int,y:int}", "int[]"} {
+		xlsx.SetCellStr("Sheet1", excelize.ToAlphaString(i)+"2", v)
+	}
+	for r := 4; r < 50004; r++ {
+		xlsx.SetCellStr("Sheet1", fmt.Sprintf("A%d", r), fmt.Sprint(r))
+		xlsx.SetCellStr("Sheet1", fmt.Sprintf("B%d", r), fmt.Sprintf("name%d", r))
+		xlsx.SetCellStr("Sheet1", fmt.Sprintf("C%d", r), fmt.Sprint(r%100))
+		xlsx.SetCellStr("Sheet1", fmt.Sprintf("D%d", r), fmt.Sprintf("{x:%d,y:%d}", r, r))
+		xlsx.SetCellStr("Sheet1", fmt.Sprintf("E%d", r), "[1,2,3]")
+	}
+	if err := xlsx.SaveAs(filepath.Join(dir, "Loot.xlsx")); err != nil {
+		b.Fatal(err)
+	}
+	return dir
+}
+
+// 在后台每毫秒采样一次堆内存,返回的函数停止采样并返回峰值
+func samplePeakHeap() func() uint64 {
+	var peak uint64
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		var m runtime.MemStats
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			runtime.ReadMemStats(&m)
+			if m.HeapInuse > peak {
+				peak = m.HeapInuse
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() uint64 {
+		close(done)
+		<-stopped
+		return peak
+	}
+}
+
+func benchmarkExport(b *testing.B, stream bool) {
+	dir := benchTable(b)
+	tmpl, _ := template.New("test").Parse(jsonTemplate)
+	b.ReportAllocs()
+	runtime.GC()
+	stop := samplePeakHeap()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w := &Walker{
+			loadPath:   dir,
+			writePath:  filepath.Join(dir, "output"),
+			tmpl:       tmpl,
+			funcOutput: outputJson,
+			funcStream: streamJson,
+			report:     &Reporter{},
+			stream:     stream,
+		}
+		w.walk()
+		if w.report.Errors() > 0 {
+			w.report.Flush()
+			b.Fatal("export failed")
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(stop())/(1<<20), "peak-heap-MB")
+}
+
+func BenchmarkExport(b *testing.B) {
+	benchmarkExport(b, false)
+}
+
+func BenchmarkStreamExport(b *testing.B) {
+	benchmarkExport(b, true)
+}
+
+func TestJobs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"C", "A", "B"} {
+		writeXlsx(t, dir, name, [][]string{
+			{"id", "name"},
+			{"int", "string"},
+			{"", ""},
+			{"1", name},
+		})
+	}
+	for _, jobs := range []int{1, 2, 8} {
+		w := &Walker{
+			loadPath: dir,
+			report:   &Reporter{},
+			jobs:     jobs,
+		}
+		tables := w.load()
+		assert.Equal(t, 3, len(tables))
+		for i, name := range []string{"A", "B", "C"} {
+			assert.Equal(t, name, tables[i].name)
+		}
+	}
+
+	w := &Walker{jobs: 3}
+	var mu sync.Mutex
+	seen := map[int]bool{}
+	w.parallel(100, func(i int) {
+		mu.Lock()
+		seen[i] = true
+		mu.Unlock()
+	})
+	assert.Equal(t, 100, len(seen))
+
+	//同时执行的数量不超过jobs
+	for _, jobs := range []int{1, 3} {
+		w := &Walker{jobs: jobs}
+		running, max := 0, 0
+		w.parallel(12, func(i int) {
+			mu.Lock()
+			if running++; running > max {
+				max = running
+			}
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+		})
+		assert.Equal(t, jobs, max)
+	}
+}
+
+func TestGoTables(t *testing.T) {
+	dir := t.TempDir()
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "name"},
+		{"int", "string"},
+		{"", ""},
+		{"1", "apple"},
+	})
+	writeXlsx(t, dir, "Hero", [][]string{
+		{"id", "hp"},
+		{"int", "int"},
+		{"", ""},
+		{"1", "100"},
+	})
+	g := &goGenerator{Package: "conf"}
+	w := newGoWalker(dir, dir, g)
+	w.walk()
+	assert.Equal(t, 0, w.report.Errors())
+
+	item, _ := os.ReadFile(filepath.Join(dir, "conf", "Item.go"))
+	assert.Contains(t, string(item), "type Item struct")
+	assert.Contains(t, string(item), "func LoadItemFromFile(")
+	assert.NotContains(t, string(item), "type Hero struct")
+	hero, _ := os.ReadFile(filepath.Join(dir, "conf", "Hero.go"))
+	assert.Contains(t, string(hero), "type Hero struct")
+	assert.NotContains(t, string(hero), "type Item struct")
+
+	tables, _ := os.ReadFile(filepath.Join(dir, "conf", "tables.go"))
+	assert.Contains(t, string(tables), "func LoadAll(dir string) error")
+	hi := strings.Index(string(tables), `readHeroTable(filepath.Join(dir, "Hero.json"))`)
+	ii := strings.Index(string(tables), `readItemTable(filepath.Join(dir, "Item.json"))`)
+	assert.True(t, hi > 0 && ii > hi)
+
+	//再次walk(如-locale指定多个语言)时不重复生成
+	w.writePath = filepath.Join(dir, "en")
+	w.walk()
+	tables, _ = os.ReadFile(filepath.Join(dir, "en", "conf", "tables.go"))
+	assert.Equal(t, 1, strings.Count(string(tables), "tItem *_ItemTable"))
+}
+
+func TestGoLiteral(t *testing.T) {
+	dir := t.TempDir()
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "name", "rate", "pos", "list", "tips"},
+		{"string", "string", "float", "{x:int,y:int}", "{a:int}[]", "string[]"},
+		{"", "", "", "", "", ""},
+		{"apple", "a\"pple", "0.5", "{x:1,y:2}", "[{a:1},{a:2}]", `["x","y"]`},
+		{"pear", "", "", "", "", ""},
+	})
+	g := &goGenerator{Package: "main", data: "literal"}
+	pkg := genGo(t, dir, g)
+
+	//生成的代码不读取json文件即可使用
+	out := goRun(t, pkg, `
+	m, _ := GetItem("apple")
+	fmt.Printf("%+v", *m)`)
+	assert.Equal(t, `{Id:apple Name:a"pple Rate:0.5 Pos:{X:1 Y:2} List:[{A:1} {A:2}] Tips:[x y]}`, out)
+}
+
+// 用g把dir下的表生成go代码到output
+func newGoWalker(dir string, output string, g *goGenerator) *Walker {
+	text := goTemplate
+	if g.runtime {
+		text = goRuntimeTemplate
+	}
+	tmpl, _ := template.New("test").Parse(text)
+	return &Walker{
+		loadPath:   dir,
+		writePath:  output,
+		tmpl:       tmpl,
+		funcOutput: g.outputGo,
+		funcOk:     g.walkOk,
+		funcNames:  g.names,
+		report:     &Reporter{},
+	}
+}
+
+// 生成go代码到dir/output,返回生成的包的目录
+func genGo(t *testing.T, dir string, g *goGenerator) string {
+	w := newGoWalker(dir, filepath.Join(dir, "output"), g)
+	w.walk()
+	assert.Equal(t, 0, w.report.Errors())
+	return filepath.Join(dir, "output", g.Package)
+}
+
+// 在生成的main包中添加main函数并运行,返回输出
+func goRun(t *testing.T, pkg string, body string) string {
+	//-goruntime=true生成的代码引用本模块的table包
+	root, _ := filepath.Abs(".")
+	os.WriteFile(filepath.Join(pkg, "go.mod"), []byte("module item\n\ngo 1.19\n\nrequire github.com/sniperHW/tabgo v0.0.0\n\nreplace github.com/sniperHW/tabgo => "+root+"\n"), os.ModePerm)
+	os.WriteFile(filepath.Join(pkg, "main.go"), []byte("package main\n\nimport \"fmt\"\n\nfunc main() {"+body+"\n}\n"), os.ModePerm)
+	cmd := exec.Command("go", "run", ".")
+	cmd.Dir = pkg
+	out, err := cmd.CombinedOutput()
+	assert.Nil(t, err, string(out))
+	return string(out)
+}
+
+func TestGoEmbed(t *testing.T) {
+	dir := t.TempDir()
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "name"},
+		{"int", "string"},
+		{"", ""},
+		{"1", "apple"},
+	})
+	g := &goGenerator{Package: "main", data: "embed"}
+	pkg := genGo(t, dir, g)
+
+	_, err := os.Stat(filepath.Join(pkg, "Item.json"))
+	assert.Nil(t, err)
+	os.WriteFile(filepath.Join(dir, "fix.json"), []byte(`{"1":{"id":1,"name":"pear"}}`), os.ModePerm)
+	out := goRun(t, pkg, `
+	if err := LoadEmbedded(); err != nil {
+		panic(err)
+	}
+	m, _ := GetItem(1)
+	fmt.Print(m.Name)
+	//热更新时从文件覆盖
+	if err := LoadItemFromFile("`+filepath.ToSlash(filepath.Join(dir, "fix.json"))+`"); err != nil {
+		panic(err)
+	}
+	m, _ = GetItem(1)
+	fmt.Print(",", m.Name)`)
+	assert.Equal(t, "apple,pear", out)
+}
+
+func TestGoIndex(t *testing.T) {
+	dir := t.TempDir()
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "name:index", "group:multiindex,server"},
+		{"int", "string", "int"},
+		{"", "", ""},
+		{"3", "c", "1"},
+		{"1", "a", "1"},
+		{"2", "b", "2"},
+	})
+	g := &goGenerator{Package: "main", data: "literal"}
+	w := newGoWalker(dir, filepath.Join(dir, "output"), g)
+	//index和multiindex不参与标记表达式
+	w.tags, _ = parseTags("!client")
+	w.walk()
+	assert.Equal(t, 0, w.report.Errors())
+	pkg := filepath.Join(dir, "output", "main")
+
+	out := goRun(t, pkg, `
+	m, ok := GetItemByName("b")
+	fmt.Print(m.Id, ok)
+	_, ok = GetItemByName("x")
+	fmt.Print(",", ok)
+	for _, v := range GetItemsByGroup(1) {
+		fmt.Print(",", v.Id)
+	}
+	//重新加载时索引一起替换
+	LoadItemFromString(`+"`"+`{"5":{"id":5,"name":"e","group":1}}`+"`"+`)
+	_, ok = GetItemByName("b")
+	fmt.Print(",", ok, len(GetItemsByGroup(1)))`)
+	assert.Equal(t, "2 true,false,1,3,false 1", out)
+
+	//唯一索引不能重复,不能为空
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "name:index", "list:multiindex"},
+		{"int", "string", "int[]"},
+		{"", "", ""},
+		{"1", "a", ""},
+		{"2", "a", ""},
+		{"3", "", ""},
+	})
+	w = &Walker{
+		loadPath: dir,
+		report:   &Reporter{},
+	}
+	w.load()
+	assert.Equal(t, 1, w.report.Errors())
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "name:index"},
+		{"int", "string"},
+		{"", ""},
+		{"1", "a"},
+		{"2", "a"},
+		{"3", ""},
+	})
+	w.load()
+	assert.Equal(t, 3, w.report.Errors())
+}
+
+func TestGoOrder(t *testing.T) {
+	dir := t.TempDir()
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "group:multiindex"},
+		{"int", "int"},
+		{"", ""},
+		{"3", "1"},
+		{"1", "1"},
+		{"2", "2"},
+	})
+	run := func(g *goGenerator, body string) string {
+		output := filepath.Join(dir, g.data+g.order)
+		w := newGoWalker(dir, output, g)
+		w.walk()
+		assert.Equal(t, 0, w.report.Errors())
+		return goRun(t, filepath.Join(output, "main"), body)
+	}
+
+	out := run(&goGenerator{Package: "main", data: "literal", order: "id", binary: true}, `
+	ForEachItem(func(m *Item) bool {
+		fmt.Print(m.Id, ",")
+		return true
+	})
+	for _, v := range GetItemsByGroup(1) {
+		fmt.Print(v.Id, ",")
+	}
+	_, ok := GetItem(2)
+	_, ok1 := GetItem(5)
+	fmt.Print(ok, ok1)`)
+	assert.Equal(t, "1,2,3,1,3,true false", out)
+
+	out = run(&goGenerator{Package: "main", data: "literal", order: "row"}, `
+	for _, v := range AllItem() {
+		fmt.Print(v.Id, ",")
+	}
+	for _, v := range GetItemsByGroup(1) {
+		fmt.Print(v.Id, ",")
+	}
+	LoadItemFromString(`+"`"+`{"9":{"id":9},"5":{"id":5},"7":{"id":7}}`+"`"+`)
+	for _, v := range AllItem() {
+		fmt.Print(v.Id, ",")
+	}`)
+	assert.Equal(t, "3,1,2,3,1,9,5,7,", out)
+}
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "name"},
+		{"int", "string"},
+		{"", ""},
+		{"1", "apple"},
+		{"2", "pear"},
+	})
+	writeXlsx(t, dir, "Shop", [][]string{
+		{"id", "itemId:ref=Item", "items:ref=Item"},
+		{"int", "int", "int[]"},
+		{"", "", ""},
+		{"1", "1", "[1,2]"},
+		{"2", "", ""},
+	})
+	g := &goGenerator{Package: "main", data: "embed"}
+	pkg := genGo(t, dir, g)
+
+	bad := filepath.Join(dir, "bad")
+	os.MkdirAll(bad, os.ModePerm)
+	os.WriteFile(filepath.Join(bad, "Item.json"), []byte(`{"1":{"id":1,"name":"x"}}`), os.ModePerm)
+	os.WriteFile(filepath.Join(bad, "Shop.json"), []byte(`{"1":{"id":1,"itemId":1,"items":[1,9]}}`), os.ModePerm)
+	good := filepath.Join(dir, "good")
+	os.MkdirAll(good, os.ModePerm)
+	os.WriteFile(filepath.Join(good, "Item.json"), []byte(`{"3":{"id":3,"name":"y"}}`), os.ModePerm)
+	os.WriteFile(filepath.Join(good, "Shop.json"), []byte(`{"1":{"id":1,"itemId":3}}`), os.ModePerm)
+	out := goRun(t, pkg, `
+	if err := LoadEmbedded(); err != nil {
+		panic(err)
+	}
+	s := Snapshot()
+	//引用不存在时所有表都不替换
+	err := LoadAll("`+filepath.ToSlash(bad)+`")
+	fmt.Println(err)
+	m, _ := GetItem(1)
+	fmt.Println(Snapshot() == s, m.Name)
+	LoadAll("`+filepath.ToSlash(good)+`")
+	_, ok := GetItem(1)
+	shop, _ := GetShop(1)
+	//旧的快照保持不变
+	_, ok1 := s.GetItem(1)
+	fmt.Println(ok, shop.ItemId, ok1)`)
+	assert.Equal(t, "Shop 1: Items 9 not found in Item\ntrue apple\nfalse 3 true\n", out)
+
+	//打表时检查引用
+	writeXlsx(t, dir, "Shop", [][]string{
+		{"id", "itemId:ref=Item", "items:ref=Item", "name:ref=Item"},
+		{"int", "int", "int[]", "string"},
+		{"", "", "", ""},
+		{"1", "3", "[1,4]", ""},
+	})
+	w := &Walker{
+		loadPath: dir,
+		report:   &Reporter{},
+	}
+	w.walk()
+	assert.Equal(t, 3, w.report.Errors())
+
+	//流式打表不保留行,无法检查引用
+	tmpl, _ := template.New("test").Parse(jsonTemplate)
+	w = &Walker{
+		loadPath:   dir,
+		writePath:  filepath.Join(dir, "stream"),
+		tmpl:       tmpl,
+		funcOutput: outputJson,
+		funcStream: streamJson,
+		report:     &Reporter{},
+		stream:     true,
+	}
+	w.walk()
+	assert.Equal(t, 1, w.report.Errors())
+	_, err := os.Stat(filepath.Join(dir, "stream", "Shop.json"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestGoWatch(t *testing.T) {
+	dir := t.TempDir()
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "name"},
+		{"int", "string"},
+		{"", ""},
+		{"1", "apple"},
+	})
+	writeXlsx(t, dir, "Shop", [][]string{
+		{"id", "itemId:ref=Item"},
+		{"int", "int"},
+		{"", ""},
+		{"1", "1"},
+	})
+	g := &goGenerator{Package: "main", data: "file", Watch: true}
+	pkg := genGo(t, dir, g)
+
+	data := filepath.Join(dir, "data")
+	os.MkdirAll(data, os.ModePerm)
+	os.WriteFile(filepath.Join(data, "Item.json"), []byte(`{"1":{"id":1,"name":"apple"}}`), os.ModePerm)
+	os.WriteFile(filepath.Join(data, "Shop.json"), []byte(`{"1":{"id":1,"itemId":1}}`), os.ModePerm)
+	os.WriteFile(filepath.Join(pkg, "util.go"), []byte(`package main
+
+import (
+	"os"
+	"time"
+)
+
+func write(path, s string) {
+	os.Remove(path)
+	os.WriteFile(path, []byte(s), os.ModePerm)
+}
+
+// 用目录替换文件,读取时出错
+func mkdir(path string) {
+	os.Remove(path)
+	os.Mkdir(path, os.ModePerm)
+}
+
+func init() {
+	WatchInterval = 10 * time.Millisecond
+}
+`), os.ModePerm)
+	path := filepath.ToSlash(filepath.Join(data, "Item.json"))
+	shop := filepath.ToSlash(filepath.Join(data, "Shop.json"))
+	out := goRun(t, pkg, `
+	if err := LoadAll("`+filepath.ToSlash(data)+`"); err != nil {
+		panic(err)
+	}
+	ch := make(chan error)
+	stop := Watch("`+filepath.ToSlash(data)+`", func(err error) { ch <- err })
+	defer stop()
+	//解析出错时保留原来的数据
+	write("`+path+`", "{\"1\":{\"id\":1,")
+	fmt.Println(<-ch != nil)
+	m, _ := GetItem(1)
+	fmt.Println(m.Name)
+	write("`+path+`", "{\"1\":{\"id\":1,\"name\":\"pear\"}}")
+	fmt.Println(<-ch)
+	m, _ = GetItem(1)
+	fmt.Println(m.Name)
+	//Shop引用的Item不存在时出错,修改Item后Shop一起重新加载
+	write("`+shop+`", "{\"1\":{\"id\":1,\"itemId\":2}}")
+	fmt.Println(<-ch != nil)
+	write("`+path+`", "{\"1\":{\"id\":1,\"name\":\"pear\"},\"2\":{\"id\":2,\"name\":\"plum\"}}")
+	fmt.Println(<-ch)
+	s, _ := GetShop(1)
+	fmt.Println(s.ItemId)
+	stop()
+	//有文件读取失败时这次检查到的变化都保留到下次
+	files := map[string]*__watchFile{}
+	pollChanged("`+filepath.ToSlash(data)+`", files)
+	write("`+path+`", "{}")
+	mkdir("`+shop+`")
+	_, err := pollChanged("`+filepath.ToSlash(data)+`", files)
+	fmt.Println(err != nil)
+	write("`+shop+`", "{}")
+	changed, _ := pollChanged("`+filepath.ToSlash(data)+`", files)
+	fmt.Println(len(changed))`)
+	assert.Equal(t, "true\napple\n<nil>\npear\ntrue\n<nil>\n2\ntrue\n2\n", out)
+}
+
+func TestGoHook(t *testing.T) {
+	dir := t.TempDir()
+	writeXlsx(t, dir, "Drop", [][]string{
+		{"id", "weight"},
+		{"int", "int"},
+		{"", ""},
+		{"1", "10"},
+	})
+	g := &goGenerator{Package: "main", data: "file"}
+	pkg := genGo(t, dir, g)
+
+	out := goRun(t, pkg, `
+	SetDropHook(func(l []*Drop) (interface{}, error) {
+		total := 0
+		for _, v := range l {
+			if v.Weight < 0 {
+				return nil, fmt.Errorf("%d: negative weight", v.Id)
+			}
+			total += v.Weight
+		}
+		return total, nil
+	})
+	fmt.Println(LoadDropFromString(`+"`"+`{"1":{"id":1,"weight":10},"2":{"id":2,"weight":20}}`+"`"+`))
+	fmt.Println(DropDerived())
+	//hook返回错误时不替换
+	fmt.Println(LoadDropFromString(`+"`"+`{"1":{"id":1,"weight":-1}}`+"`"+`))
+	_, ok := GetDrop(2)
+	fmt.Println(DropDerived(), ok)`)
+	assert.Equal(t, "<nil>\n30\nDrop: 1: negative weight\n30 true\n", out)
+}
+
+func TestGoReadonly(t *testing.T) {
+	for _, data := range []string{"file", "literal"} {
+		dir := t.TempDir()
+		writeXlsx(t, dir, "Item", [][]string{
+			{"id", "name:index", "type:multiindex", "grid", "pos"},
+			{"int", "string", "int", "int[][]", "{x:int,tags:string[]}"},
+			{"", "", "", "", ""},
+			{"1", "apple", "2", "[[1,2],[3]]", `{x:1,tags:["a","b"]}`},
+		})
+		g := &goGenerator{Package: "main", data: data, readonly: true}
+		pkg := genGo(t, dir, g)
+
+		body := `
+	m, _ := GetItemByName("apple")
+	//修改返回的数组不影响表中的数据
+	m.Grid()[0][0] = 100
+	m.Pos().Tags()[0] = "c"
+	//修改All和索引返回的slice不影响表
+	AllItem()[0] = nil
+	GetItemsByType(2)[0] = nil
+	fmt.Println(AllItem()[0] != nil, GetItemsByType(2)[0] != nil)
+	m, _ = GetItem(1)
+	fmt.Println(m.Id(), m.Name(), m.Type(), m.Grid(), m.Pos().X(), m.Pos().Tags())`
+		if data == "file" {
+			os.WriteFile(filepath.Join(dir, "Item.json"), []byte(`{"1":{"id":1,"name":"apple","type":2,"grid":[[1,2],[3]],"pos":{"x":1,"tags":["a","b"]}}}`), os.ModePerm)
+			body = `
+	if err := LoadAll("` + filepath.ToSlash(dir) + `"); err != nil {
+		panic(err)
+	}` + body
+		}
+		out := goRun(t, pkg, body)
+		assert.Equal(t, "true true\n1 apple 2 [[1 2] [3]] 1 [a b]\n", out, data)
+	}
+
+	dir := t.TempDir()
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "name", "type:multiindex"},
+		{"int", "string", "int"},
+		{"", "", ""},
+		{"1", "apple", "2"},
+	})
+	g := &goGenerator{Package: "main", data: "literal", readonly: true, runtime: true}
+	pkg := genGo(t, dir, g)
+	out := goRun(t, pkg, `
+	ItemTable.All()[0] = nil
+	ItemsByType.Get(2)[0] = nil
+	fmt.Println(ItemTable.All()[0].Name(), ItemsByType.Get(2)[0].Name())`)
+	assert.Equal(t, "apple apple\n", out)
+}
+
+func TestGoRuntime(t *testing.T) {
+	dir := t.TempDir()
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "name:index", "group:multiindex"},
+		{"int", "string", "int"},
+		{"", "", ""},
+		{"1", "apple", "1"},
+		{"2", "pear", "1"},
+	})
+	writeXlsx(t, dir, "Shop", [][]string{
+		{"id", "itemId:ref=Item", "items:ref=Item"},
+		{"int", "int", "int[]"},
+		{"", "", ""},
+		{"1", "1", "[1,2]"},
+	})
+	g := &goGenerator{Package: "main", data: "embed", runtime: true, Watch: true}
+	pkg := genGo(t, dir, g)
+
+	bad := filepath.Join(dir, "bad")
+	os.MkdirAll(bad, os.ModePerm)
+	os.WriteFile(filepath.Join(bad, "Item.json"), []byte(`{"1":{"id":1,"name":"x"}}`), os.ModePerm)
+	os.WriteFile(filepath.Join(bad, "Shop.json"), []byte(`{"1":{"id":1,"itemId":1,"items":[1,9]}}`), os.ModePerm)
+	good := filepath.Join(dir, "good")
+	os.MkdirAll(good, os.ModePerm)
+	os.WriteFile(filepath.Join(good, "Item.json"), []byte(`{"3":{"id":3,"name":"plum","group":2}}`), os.ModePerm)
+	os.WriteFile(filepath.Join(good, "Shop.json"), []byte(`{"1":{"id":1,"itemId":3}}`), os.ModePerm)
+	out := goRun(t, pkg, `
+	if err := LoadEmbedded(); err != nil {
+		panic(err)
+	}
+	m, _ := ItemByName.Get("pear")
+	shop, _ := ShopTable.Get(1)
+	fmt.Println(m.Id, len(ItemsByGroup.Get(1)), shop.Items)
+	s := Snapshot()
+	//引用不存在时所有表都不替换
+	fmt.Println(LoadAll("`+filepath.ToSlash(bad)+`"))
+	m, _ = ItemTable.Get(1)
+	fmt.Println(m.Name, Snapshot() == s)
+	//所有表一起替换,旧的快照保持不变
+	LoadAll("`+filepath.ToSlash(good)+`")
+	shop, _ = ShopTable.Get(1)
+	_, ok := ItemTable.Get(1)
+	m, _ = ItemTable.In(s).Get(1)
+	old, _ := ShopTable.In(s).Get(1)
+	fmt.Println(shop.ItemId, ok, m.Name, old.ItemId, len(ItemsByGroup.GetIn(s, 1)), len(ItemsByGroup.Get(1)))`)
+	assert.Equal(t, "2 2 [1 2]\nShop 1: Items 9 not found in Item\napple true\n3 false apple 1 2 0\n", out)
+
+	//生成的代码中没有加载逻辑
+	b, _ := os.ReadFile(filepath.Join(pkg, "Item.go"))
+	assert.False(t, strings.Contains(string(b), "json.NewDecoder"))
+}
+
+func TestGoConst(t *testing.T) {
+	assert.Equal(t, "CurrencyGold", constName("Currency", "GOLD"))
+	assert.Equal(t, "CurrencyBigGold", constName("Currency", "BIG_GOLD"))
+	assert.Equal(t, "CurrencyBigGold", constName("Currency", "bigGold"))
+
+	dir := t.TempDir()
+	writeXlsx(t, dir, "Currency", [][]string{
+		{"id", "key:const"},
+		{"int", "string"},
+		{"", ""},
+		{"1", "GOLD"},
+		{"2", "DIAMOND"},
+		{"3", ""},
+	})
+	g := &goGenerator{Package: "main", data: "literal"}
+	pkg := genGo(t, dir, g)
+	out := goRun(t, pkg, `
+	m, _ := GetCurrency(CurrencyDiamond)
+	fmt.Println(CurrencyGold, m.Key)`)
+	assert.Equal(t, "1 DIAMOND\n", out)
+
+	//不合法和重复的名字
+	writeXlsx(t, dir, "Currency", [][]string{
+		{"id", "key:const"},
+		{"int", "string"},
+		{"", ""},
+		{"1", "GOLD"},
+		{"2", "Gold"},
+		{"3", "1st"},
+		{"4", "a-b"},
+	})
+	w := &Walker{
+		loadPath: dir,
+		report:   &Reporter{},
+	}
+	w.walk()
+	assert.Equal(t, 3, w.report.Errors())
+
+	writeXlsx(t, dir, "Currency", [][]string{
+		{"id", "key:const"},
+		{"int", "int"},
+		{"", ""},
+		{"1", "1"},
+	})
+	w = &Walker{
+		loadPath: dir,
+		report:   &Reporter{},
+	}
+	w.walk()
+	assert.Equal(t, 1, w.report.Errors())
+
+	//与生成的结构体类型,函数重名
+	dir = t.TempDir()
+	writeXlsx(t, dir, "Item", [][]string{
+		{"id", "pos", "key:const"},
+		{"int", "{x:int,y:int}", "string"},
+		{"", "", ""},
+		{"1", "{x:1,y:2}", "POS"},
+		{"2", "{x:1,y:2}", "DERIVED"},
+		{"3", "{x:1,y:2}", "TABLE"},
+	})
+	w = newGoWalker(dir, filepath.Join(dir, "output"), &goGenerator{Package: "main"})
+	w.walk()
+	assert.Equal(t, 2, w.report.Errors())
+	//-goruntime生成ItemTable变量
+	w = newGoWalker(dir, filepath.Join(dir, "output"), &goGenerator{Package: "main", runtime: true})
+	w.walk()
+	assert.Equal(t, 2, w.report.Errors())
+	assert.Contains(t, w.report.diags[1].String(), "ItemTable")
+
+	//不同表生成的常量名重复
+	dir = t.TempDir()
+	writeXlsx(t, dir, "A", [][]string{
+		{"id", "key:const"},
+		{"int", "string"},
+		{"", ""},
+		{"1", "bC"},
+	})
+	writeXlsx(t, dir, "AB", [][]string{
+		{"id", "key:const"},
+		{"int", "string"},
+		{"", ""},
+		{"1", "C"},
+	})
+	w = &Walker{
+		loadPath: dir,
+		report:   &Reporter{},
+	}
+	w.walk()
+	assert.Equal(t, 1, w.report.Errors())
+	assert.Contains(t, w.report.diags[0].String(), "ABC")
+}
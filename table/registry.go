@@ -0,0 +1,44 @@
+package table
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// 一组表在某一时刻的数据,发布后不再修改
+type Snapshot struct {
+	rows map[interface{}]interface{} //*Table -> *Rows
+}
+
+// 一组一起加载的表,所有表的数据保存在一个快照中,加载时整体原子替换
+type Registry struct {
+	mu       sync.Mutex //同一时间只有一个Update
+	snapshot atomic.Pointer[Snapshot]
+}
+
+func NewRegistry() *Registry {
+	r := &Registry{}
+	r.snapshot.Store(&Snapshot{rows: map[interface{}]interface{}{}})
+	return r
+}
+
+// 返回当前快照,一次请求中通过Table.In读取同一个快照,即使期间发生了重新加载,看到的所有表也是一致的
+func (r *Registry) Snapshot() *Snapshot {
+	return r.snapshot.Load()
+}
+
+// 复制当前快照,fn通过Table.Put替换其中的表,返回nil时一次性发布新的快照,返回错误时不替换任何表
+func (r *Registry) Update(fn func(s *Snapshot) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old := r.Snapshot()
+	s := &Snapshot{rows: make(map[interface{}]interface{}, len(old.rows))}
+	for t, rows := range old.rows {
+		s.rows[t] = rows
+	}
+	if err := fn(s); err != nil {
+		return err
+	}
+	r.snapshot.Store(s)
+	return nil
+}
@@ -0,0 +1,319 @@
+// Package table是-goruntime=true时生成的go代码使用的运行时。
+//
+// 生成的代码只包含结构体定义和每个表的变量,加载,查找,遍历,索引和热更新都由这个包实现,
+// 修改这个包不需要重新生成代码。
+package table
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync/atomic"
+)
+
+// 可以作为id的类型
+type Ordered interface {
+	~int | ~int64 | ~float64 | ~string
+}
+
+// 一次加载得到的所有行和索引,加载时整体替换,不要修改其中的数据
+type Rows[K Ordered, V any] struct {
+	t       *Table[K, V]
+	m       map[K]*V
+	l       []*V
+	indexes []interface{}
+	derived interface{} //hook返回的数据
+}
+
+func (r *Rows[K, V]) Get(id K) (*V, bool) {
+	if r.t.binary {
+		l := r.l
+		i := sort.Search(len(l), func(i int) bool { return r.t.id(l[i]) >= id })
+		if i < len(l) && r.t.id(l[i]) == id {
+			return l[i], true
+		}
+		return nil, false
+	}
+	v, ok := r.m[id]
+	return v, ok
+}
+
+// 返回按id(RowOrder时按行序)排列的所有行,Readonly时返回副本,否则不要修改返回的slice
+func (r *Rows[K, V]) All() []*V {
+	if r.t.readonly {
+		return append([]*V(nil), r.l...)
+	}
+	return r.l
+}
+
+func (r *Rows[K, V]) Len() int {
+	return len(r.l)
+}
+
+// 返回hook返回的数据
+func (r *Rows[K, V]) Derived() interface{} {
+	return r.derived
+}
+
+// 一个表,保存当前的Rows,加载时原子替换。Register后Rows保存在Registry的快照中
+type Table[K Ordered, V any] struct {
+	name     string
+	id       func(v *V) K
+	rowOrder bool
+	binary   bool
+	readonly bool
+	builders []func(l []*V) interface{} //索引
+	hook     func(l []*V) (interface{}, error)
+	reg      *Registry
+	rows     atomic.Pointer[Rows[K, V]]
+}
+
+// 创建表,id返回一行的id
+func New[K Ordered, V any](name string, id func(v *V) K) *Table[K, V] {
+	return &Table[K, V]{name: name, id: id}
+}
+
+// All和ForEach按json中的顺序排列,不按id排序
+func (t *Table[K, V]) RowOrder() *Table[K, V] {
+	t.rowOrder = true
+	return t
+}
+
+// Get在按id排序的行上二分查找,不能与RowOrder同时使用
+func (t *Table[K, V]) BinarySearch() *Table[K, V] {
+	if t.rowOrder {
+		panic("table: BinarySearch requires rows ordered by id")
+	}
+	t.binary = true
+	return t
+}
+
+// All和索引返回slice的副本,用于-goreadonly=true生成的只读结构体
+func (t *Table[K, V]) Readonly() *Table[K, V] {
+	t.readonly = true
+	return t
+}
+
+// 加入reg,与reg中的其它表一起通过Registry.Update替换。应在加载之前调用
+func (t *Table[K, V]) Register(reg *Registry) *Table[K, V] {
+	t.reg = reg
+	return t
+}
+
+func (t *Table[K, V]) Name() string {
+	return t.name
+}
+
+// 设置加载时调用的hook,在替换之前调用,返回错误时放弃加载。应在加载之前设置
+func (t *Table[K, V]) SetHook(fn func(l []*V) (derived interface{}, err error)) {
+	t.hook = fn
+}
+
+// 返回当前的所有行,一次请求中使用同一个Rows可以看到一致的数据
+func (t *Table[K, V]) Rows() *Rows[K, V] {
+	if t.reg != nil {
+		return t.In(t.reg.Snapshot())
+	}
+	if r := t.rows.Load(); r != nil {
+		return r
+	}
+	return &Rows[K, V]{t: t}
+}
+
+// 返回快照s中这个表的所有行,s为nil时返回当前的数据
+func (t *Table[K, V]) In(s *Snapshot) *Rows[K, V] {
+	if s == nil {
+		return t.Rows()
+	}
+	if r, ok := s.rows[t].(*Rows[K, V]); ok {
+		return r
+	}
+	return &Rows[K, V]{t: t}
+}
+
+// 在Registry.Update中把快照s中这个表替换为r
+func (t *Table[K, V]) Put(s *Snapshot, r *Rows[K, V]) {
+	s.rows[t] = r
+}
+
+func (t *Table[K, V]) Get(id K) (*V, bool) {
+	return t.Rows().Get(id)
+}
+
+func (t *Table[K, V]) All() []*V {
+	return t.Rows().All()
+}
+
+func (t *Table[K, V]) ForEach(fn func(v *V) bool) {
+	for _, v := range t.Rows().l {
+		if !fn(v) {
+			break
+		}
+	}
+}
+
+func (t *Table[K, V]) Derived() interface{} {
+	return t.Rows().Derived()
+}
+
+func (t *Table[K, V]) makeRows(l []*V) *Rows[K, V] {
+	if !t.rowOrder {
+		sort.Slice(l, func(i, j int) bool { return t.id(l[i]) < t.id(l[j]) })
+	}
+	r := &Rows[K, V]{t: t, m: make(map[K]*V, len(l)), l: l}
+	for _, v := range l {
+		r.m[t.id(v)] = v
+	}
+	for _, b := range t.builders {
+		r.indexes = append(r.indexes, b(l))
+	}
+	return r
+}
+
+// 按json中的顺序解码,生成索引并调用hook,不替换当前的数据
+func (t *Table[K, V]) Decode(b []byte) (*Rows[K, V], error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	if tok, err := d.Token(); err != nil {
+		return nil, fmt.Errorf("%s: %w", t.name, err)
+	} else if tok != json.Delim('{') {
+		return nil, errors.New(t.name + ": invalid json")
+	}
+	var l []*V
+	for d.More() {
+		if _, err := d.Token(); err != nil {
+			return nil, fmt.Errorf("%s: %w", t.name, err)
+		}
+		v := new(V)
+		if err := d.Decode(v); err != nil {
+			return nil, fmt.Errorf("%s: %w", t.name, err)
+		}
+		l = append(l, v)
+	}
+	r := t.makeRows(l)
+	if t.hook != nil {
+		var err error
+		if r.derived, err = t.hook(r.l); err != nil {
+			return nil, fmt.Errorf("%s: %w", t.name, err)
+		}
+	}
+	return r, nil
+}
+
+func (t *Table[K, V]) DecodeFile(path string) (*Rows[K, V], error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return t.Decode(b)
+}
+
+// 替换当前的数据,Register后只替换快照中的这一个表
+func (t *Table[K, V]) Swap(r *Rows[K, V]) {
+	if t.reg != nil {
+		t.reg.Update(func(s *Snapshot) error {
+			t.Put(s, r)
+			return nil
+		})
+		return
+	}
+	t.rows.Store(r)
+}
+
+// 解码并替换,出错时保留原来的数据
+func (t *Table[K, V]) Load(b []byte) error {
+	r, err := t.Decode(b)
+	if err != nil {
+		return err
+	}
+	t.Swap(r)
+	return nil
+}
+
+func (t *Table[K, V]) LoadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return t.Load(b)
+}
+
+// 用生成的go字面量填充表,不调用hook
+func (t *Table[K, V]) Set(l []*V) {
+	t.Swap(t.makeRows(l))
+}
+
+// 唯一索引
+type Index[IK comparable, V any] struct {
+	i       int
+	indexes func(s *Snapshot) []interface{}
+}
+
+// 为表添加唯一索引,key返回一行的索引值。应在加载之前添加
+func NewIndex[IK comparable, K Ordered, V any](t *Table[K, V], key func(v *V) IK) *Index[IK, V] {
+	x := &Index[IK, V]{i: len(t.builders), indexes: func(s *Snapshot) []interface{} { return t.In(s).indexes }}
+	t.builders = append(t.builders, func(l []*V) interface{} {
+		m := make(map[IK]*V, len(l))
+		for _, v := range l {
+			m[key(v)] = v
+		}
+		return m
+	})
+	return x
+}
+
+func (x *Index[IK, V]) Get(k IK) (*V, bool) {
+	return x.GetIn(nil, k)
+}
+
+// 在快照s中查找,s为nil时查找当前的数据
+func (x *Index[IK, V]) GetIn(s *Snapshot, k IK) (*V, bool) {
+	if indexes := x.indexes(s); x.i < len(indexes) {
+		v, ok := indexes[x.i].(map[IK]*V)[k]
+		return v, ok
+	}
+	return nil, false
+}
+
+// 非唯一索引
+type MultiIndex[IK comparable, V any] struct {
+	i        int
+	indexes  func(s *Snapshot) []interface{}
+	readonly func() bool
+}
+
+// 为表添加非唯一索引,同一个值的行按表的顺序排列。应在加载之前添加
+func NewMultiIndex[IK comparable, K Ordered, V any](t *Table[K, V], key func(v *V) IK) *MultiIndex[IK, V] {
+	x := &MultiIndex[IK, V]{
+		i:        len(t.builders),
+		indexes:  func(s *Snapshot) []interface{} { return t.In(s).indexes },
+		readonly: func() bool { return t.readonly },
+	}
+	t.builders = append(t.builders, func(l []*V) interface{} {
+		m := map[IK][]*V{}
+		for _, v := range l {
+			m[key(v)] = append(m[key(v)], v)
+		}
+		return m
+	})
+	return x
+}
+
+// 表为Readonly时返回副本,否则不要修改返回的slice
+func (x *MultiIndex[IK, V]) Get(k IK) []*V {
+	return x.GetIn(nil, k)
+}
+
+// 在快照s中查找,s为nil时查找当前的数据
+func (x *MultiIndex[IK, V]) GetIn(s *Snapshot, k IK) []*V {
+	if indexes := x.indexes(s); x.i < len(indexes) {
+		l := indexes[x.i].(map[IK][]*V)[k]
+		if x.readonly() {
+			return append([]*V(nil), l...)
+		}
+		return l
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package table
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTable(t *testing.T) {
+	type item struct {
+		Id    int    `json:"id"`
+		Name  string `json:"name"`
+		Group int    `json:"group"`
+	}
+	items := New("Item", func(v *item) int { return v.Id })
+	byName := NewIndex(items, func(v *item) string { return v.Name })
+	byGroup := NewMultiIndex(items, func(v *item) int { return v.Group })
+	_, ok := byName.Get("apple")
+	assert.False(t, ok)
+
+	assert.Nil(t, items.Load([]byte(`{"2":{"id":2,"name":"pear","group":1},"1":{"id":1,"name":"apple","group":1}}`)))
+	m, _ := items.Get(2)
+	assert.Equal(t, "pear", m.Name)
+	m, _ = byName.Get("apple")
+	assert.Equal(t, 1, m.Id)
+	assert.Equal(t, 2, len(byGroup.Get(1)))
+	assert.Equal(t, 1, items.All()[0].Id)
+
+	//hook返回错误时保留原来的数据
+	items.SetHook(func(l []*item) (interface{}, error) {
+		if len(l) == 0 {
+			return nil, fmt.Errorf("empty")
+		}
+		return len(l), nil
+	})
+	assert.Equal(t, "Item: empty", items.Load([]byte(`{}`)).Error())
+	assert.Equal(t, "Item: invalid json", items.Load([]byte(`[]`)).Error())
+	assert.Equal(t, 2, len(items.All()))
+	assert.Nil(t, items.Load([]byte(`{"3":{"id":3}}`)))
+	assert.Equal(t, 1, items.Derived())
+	_, ok = byName.Get("apple")
+	assert.False(t, ok)
+
+	sorted := New("Item", func(v *item) int { return v.Id }).BinarySearch()
+	sorted.Set([]*item{{Id: 5}, {Id: 3}, {Id: 4}})
+	_, ok = sorted.Get(4)
+	assert.True(t, ok)
+	_, ok = sorted.Get(6)
+	assert.False(t, ok)
+
+	//同一个Registry中的表一起替换
+	reg := NewRegistry()
+	a := New("A", func(v *item) int { return v.Id }).Register(reg)
+	b := New("B", func(v *item) int { return v.Id }).Register(reg)
+	byNameA := NewIndex(a, func(v *item) string { return v.Name })
+	a.Set([]*item{{Id: 1, Name: "apple"}})
+	s := reg.Snapshot()
+	assert.NotNil(t, reg.Update(func(s *Snapshot) error {
+		r, _ := a.Decode([]byte(`{"2":{"id":2,"name":"pear"}}`))
+		a.Put(s, r)
+		return fmt.Errorf("invalid")
+	}))
+	assert.True(t, reg.Snapshot() == s)
+	assert.Nil(t, reg.Update(func(s *Snapshot) error {
+		r, _ := a.Decode([]byte(`{"2":{"id":2,"name":"pear"}}`))
+		a.Put(s, r)
+		r, _ = b.Decode([]byte(`{"3":{"id":3}}`))
+		b.Put(s, r)
+		return nil
+	}))
+	_, ok = a.Get(2)
+	assert.True(t, ok)
+	assert.Equal(t, 1, b.Rows().Len())
+	_, ok = byNameA.GetIn(s, "apple")
+	assert.True(t, ok)
+	assert.Equal(t, 0, b.In(s).Len())
+
+	//只读表返回副本
+	readonly := New("Item", func(v *item) int { return v.Id }).Readonly()
+	readonlyByGroup := NewMultiIndex(readonly, func(v *item) int { return v.Group })
+	readonly.Set([]*item{{Id: 1, Group: 1}, {Id: 2, Group: 1}})
+	readonly.All()[0] = nil
+	readonly.Rows().All()[0] = nil
+	readonlyByGroup.Get(1)[0] = nil
+	assert.Equal(t, 1, readonly.All()[0].Id)
+	assert.Equal(t, 1, readonlyByGroup.Get(1)[0].Id)
+}
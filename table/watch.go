@@ -0,0 +1,92 @@
+package table
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type watchFile struct {
+	modTime time.Time
+	size    int64
+	sum     [sha256.Size]byte
+}
+
+// 返回内容发生变化的文件,只修改了时间的文件不算变化
+func pollChanged(dir string, names []string, files map[string]*watchFile) (map[string][]byte, error) {
+	changed := map[string][]byte{}
+	polled := map[string]*watchFile{}
+	for _, name := range names {
+		path := filepath.Join(dir, name+".json")
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		old := files[name]
+		if old != nil && old.modTime.Equal(fi.ModTime()) && old.size == fi.Size() {
+			continue
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		f := &watchFile{modTime: fi.ModTime(), size: fi.Size(), sum: sha256.Sum256(b)}
+		polled[name] = f
+		if old == nil || old.sum != f.sum {
+			changed[name] = b
+		}
+	}
+	//所有文件都读取成功后才更新files,否则下次重新检查
+	for name, f := range polled {
+		files[name] = f
+	}
+	return changed, nil
+}
+
+// 每隔interval检查dir中的names.json,把内容变化的文件(表名->内容)传给reload。
+// reload出错的文件在下次有文件变化时一起传给reload。
+// 每次重新加载后调用onReload,成功时err为nil。调用stop停止监视
+func Watch(dir string, names []string, interval time.Duration, reload func(changed map[string][]byte) error, onReload func(err error)) (stop func()) {
+	files := map[string]*watchFile{}
+	pollChanged(dir, names, files)
+	//加载失败的表,下次有文件变化时重试
+	var pending map[string][]byte
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			changed, err := pollChanged(dir, names, files)
+			if err == nil && len(changed) == 0 {
+				continue
+			}
+			if err == nil {
+				//上次失败的表和这次变化的表一起重新加载
+				for name, b := range pending {
+					if _, ok := changed[name]; !ok {
+						changed[name] = b
+					}
+				}
+				if err = reload(changed); err != nil {
+					pending = changed
+				} else {
+					pending = nil
+				}
+			}
+			if onReload != nil {
+				onReload(err)
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
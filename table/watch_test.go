@@ -0,0 +1,65 @@
+package table
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "A.json"), []byte("1"), os.ModePerm)
+	os.WriteFile(filepath.Join(dir, "B.json"), []byte("1"), os.ModePerm)
+	ch := make(chan []string)
+	//B依赖A,只有B变化时出错
+	stop := Watch(dir, []string{"A", "B"}, 10*time.Millisecond, func(changed map[string][]byte) error {
+		var names []string
+		for name := range changed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		ch <- names
+		if _, ok := changed["A"]; !ok {
+			return errors.New("A required")
+		}
+		return nil
+	}, nil)
+	defer stop()
+	os.WriteFile(filepath.Join(dir, "B.json"), []byte("22"), os.ModePerm)
+	assert.Equal(t, []string{"B"}, <-ch)
+	//失败的B和A一起重新加载
+	os.WriteFile(filepath.Join(dir, "A.json"), []byte("22"), os.ModePerm)
+	assert.Equal(t, []string{"A", "B"}, <-ch)
+}
+
+func TestPollChanged(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "A.json"), []byte("1"), os.ModePerm)
+	os.WriteFile(filepath.Join(dir, "B.json"), []byte("1"), os.ModePerm)
+	files := map[string]*watchFile{}
+	pollChanged(dir, []string{"A", "B"}, files)
+
+	//只修改时间的文件不算变化
+	now := time.Now().Add(time.Second)
+	os.Chtimes(filepath.Join(dir, "A.json"), now, now)
+	changed, err := pollChanged(dir, []string{"A", "B"}, files)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(changed))
+
+	//B读取失败时A的变化保留到下次
+	os.WriteFile(filepath.Join(dir, "A.json"), []byte("22"), os.ModePerm)
+	os.Remove(filepath.Join(dir, "B.json"))
+	os.Mkdir(filepath.Join(dir, "B.json"), os.ModePerm)
+	_, err = pollChanged(dir, []string{"A", "B"}, files)
+	assert.NotNil(t, err)
+	os.Remove(filepath.Join(dir, "B.json"))
+	os.WriteFile(filepath.Join(dir, "B.json"), []byte("1"), os.ModePerm)
+	changed, err = pollChanged(dir, []string{"A", "B"}, files)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"A": []byte("22")}, changed)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 标记为annotation的列总是被忽略
+const annotationTag = "annotation"
+
+// 列属性写在标记中,但不参与-tags表达式,可以带值,如ref=Item
+const (
+	indexAttr      = "index"      //生成唯一索引,值不能为空且不能重复
+	multiIndexAttr = "multiindex" //生成多值索引
+)
+
+var columnAttrs = map[string]bool{
+	indexAttr:      true,
+	multiIndexAttr: true,
+	refAttr:        true,
+	constAttr:      true,
+}
+
+// 从标记中分离列属性
+func splitAttrs(tags []string) ([]string, map[string]string) {
+	var rest []string
+	var attrs map[string]string
+	for _, v := range tags {
+		name, value, _ := strings.Cut(v, "=")
+		if columnAttrs[trim(name)] {
+			if attrs == nil {
+				attrs = map[string]string{}
+			}
+			attrs[trim(name)] = trim(value)
+		} else {
+			rest = append(rest, v)
+		}
+	}
+	return rest, attrs
+}
+
+// 标记表达式的求值环境,标记存在时为true
+type tagEnv map[string]bool
+
+func (e tagEnv) lookup(name string) (interface{}, error) {
+	return e[name], nil
+}
+
+func (e tagEnv) call(name string, args []expr) (interface{}, error) {
+	return nil, fmt.Errorf("unknown function %s", name)
+}
+
+func makeTagEnv(tags []string) tagEnv {
+	env := tagEnv{}
+	for _, v := range tags {
+		env[v] = true
+	}
+	return env
+}
+
+// 解析-tags表达式,如server && !debug
+func parseTags(s string) (expr, error) {
+	e, err := parseExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	if v, err := e.eval(tagEnv{}); err != nil {
+		return nil, err
+	} else if _, ok := v.(bool); !ok {
+		return nil, fmt.Errorf("%s: tags expression must return bool", s)
+	}
+	return e, nil
+}
+
+// 标记集合是否满足表达式,没有标记或没有表达式时总是满足
+func matchTags(e expr, tags []string) bool {
+	if len(tags) == 0 || e == nil {
+		return true
+	}
+	v, err := e.eval(makeTagEnv(tags))
+	if err != nil {
+		return false
+	}
+	return v.(bool)
+}
+
+// 以,分隔的标记列表
+func splitTags(s string) []string {
+	var tags []string
+	for _, v := range strings.Split(s, ",") {
+		if v = trim(v); v != "" {
+			tags = append(tags, v)
+		}
+	}
+	return tags
+}
+
+// 分离列名和标记,如name:client,gm
+func splitColumnName(s string) (string, []string) {
+	v := strings.SplitN(s, ":", 2)
+	if len(v) > 1 {
+		return trim(v[0]), splitTags(v[1])
+	}
+	return trim(v[0]), nil
+}
+
+// 检查列属性,索引列只能是基本类型,唯一索引列的值不能为空且不能重复,ref列只能是基本类型或其数组
+func (c *Column) checkAttrs() error {
+	if _, ok := c.attr(constAttr); ok {
+		if p, ok := baseParser(c.parser).(*ValueParser); !ok || p.ValueType() != typeString {
+			return fmt.Errorf("const requires string column")
+		}
+	}
+	if ref, ok := c.attr(refAttr); ok {
+		if ref == "" {
+			return fmt.Errorf("ref requires table name")
+		} else if refElement(c.parser) == nil {
+			return fmt.Errorf("ref requires int, string, bool or float column or array")
+		}
+	}
+	_, index := c.attr(indexAttr)
+	_, multi := c.attr(multiIndexAttr)
+	if !index && !multi {
+		return nil
+	}
+	if index && multi {
+		return fmt.Errorf("index and multiindex can not be used together")
+	}
+	if _, ok := baseParser(c.parser).(*ValueParser); !ok {
+		return fmt.Errorf("index requires int, string, bool or float column")
+	}
+	if index {
+		c.parser = requireUnique(c.parser)
+	}
+	return nil
+}
@@ -1,10 +1,12 @@
 package test
 
 import (
+	"bytes"
 	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
 	"os"
-	"sync/atomic"
+	"sort"
 )
 
 type ModelStructY struct {
@@ -38,32 +40,115 @@ type Model struct {
 
 type _ModelMap map[int]*Model
 
-var __ModelMap atomic.Value
+// 表数据和索引,加载时一起生成,作为快照的一部分整体替换
+type _ModelTable struct {
+	m       _ModelMap
+	l       []*Model    //按id排序
+	derived interface{} //hook返回的数据
+}
 
 func init() {
-	__ModelMap.Store(make(_ModelMap))
+	setModelList(nil)
 }
 
-func getModelMap() _ModelMap {
-	return __ModelMap.Load().(_ModelMap)
+func makeModelTable(l []*Model) *_ModelTable {
+	t := &_ModelTable{m: make(_ModelMap, len(l)), l: l}
+	for _, v := range l {
+		t.m[v.Id] = v
+	}
+	return t
 }
 
-func setModelMap(m _ModelMap) {
-	__ModelMap.Store(m)
+func sortModelList(l []*Model) {
+	sort.Slice(l, func(i, j int) bool { return l[i].Id < l[j].Id })
 }
 
-func GetModel(id int) (*Model, bool) {
-	m, ok := getModelMap()[id]
+// 只替换这一个表,其它表不变
+func setModelList(l []*Model) {
+	t := makeModelTable(l)
+	updateSnapshot(func(s *Tables) {
+		s.tModel = t
+	})
+}
+
+func (s *Tables) GetModel(id int) (*Model, bool) {
+	m, ok := s.tModel.m[id]
 	return m, ok
 }
 
+func GetModel(id int) (*Model, bool) {
+	return Snapshot().GetModel(id)
+}
+
+// 校验引用其它表的列,空值不检查
+func (s *Tables) validateModel() error {
+	return nil
+}
+
+// 按json中的顺序解码
+func decodeModelTable(s []byte) (*_ModelTable, error) {
+	d := json.NewDecoder(bytes.NewReader(s))
+	if t, err := d.Token(); err != nil {
+		return nil, err
+	} else if t != json.Delim('{') {
+		return nil, errors.New("Model: invalid json")
+	}
+	var l []*Model
+	for d.More() {
+		if _, err := d.Token(); err != nil {
+			return nil, err
+		}
+		v := &Model{}
+		if err := d.Decode(v); err != nil {
+			return nil, err
+		}
+		l = append(l, v)
+	}
+	sortModelList(l)
+	t := makeModelTable(l)
+	if h := __ModelHook; h != nil {
+		var err error
+		if t.derived, err = h(t.l); err != nil {
+			return nil, fmt.Errorf("Model: %w", err)
+		}
+	}
+	return t, nil
+}
+
+var __ModelHook func(l []*Model) (interface{}, error)
+
+// 设置加载Model时调用的hook,在表替换之前调用,返回错误时放弃这次加载,原来的数据不变。
+// 返回的数据与表一起保存在快照中,通过ModelDerived取得,可以用来保存由表计算出的数据。
+// 应在加载之前设置,再次设置时替换之前的hook
+func SetModelHook(fn func(l []*Model) (derived interface{}, err error)) {
+	__ModelHook = fn
+}
+
+func (s *Tables) ModelDerived() interface{} {
+	return s.tModel.derived
+}
+
+func ModelDerived() interface{} {
+	return Snapshot().ModelDerived()
+}
+
+func readModelTable(path string) (*_ModelTable, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeModelTable(b)
+}
+
+// 只替换这一个表,不校验跨表引用
 func loadModelFromBytes(s []byte) error {
-	m := make(_ModelMap)
-	err := json.Unmarshal(s, &m)
+	t, err := decodeModelTable(s)
 	if err != nil {
 		return err
 	}
-	setModelMap(m)
+	updateSnapshot(func(s *Tables) {
+		s.tModel = t
+	})
 	return nil
 }
 
@@ -72,20 +157,24 @@ func LoadModelFromString(s string) error {
 }
 
 func LoadModelFromFile(path string) error {
-	jsonFile, err := os.Open(path)
-	if err != nil {
-		return err
-	}
-	defer jsonFile.Close()
-	jsonData, err := io.ReadAll(jsonFile)
+	b, err := os.ReadFile(path)
 	if err != nil {
 		return err
 	}
-	return loadModelFromBytes(jsonData)
+	return loadModelFromBytes(b)
+}
+
+// 返回按id排列的所有行,不要修改返回的slice
+func (s *Tables) AllModel() []*Model {
+	return s.tModel.l
+}
+
+func AllModel() []*Model {
+	return Snapshot().AllModel()
 }
 
 func ForEachModel(fn func(m *Model) bool) {
-	for _, m := range getModelMap() {
+	for _, m := range Snapshot().tModel.l {
 		if !fn(m) {
 			break
 		}
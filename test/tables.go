@@ -0,0 +1,62 @@
+package test
+
+import (
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// 所有表的一致视图,加载时整体替换,不要修改其中的数据
+type Tables struct {
+	tModel *_ModelTable
+}
+
+var __snapshot atomic.Value
+var __snapshotMu sync.Mutex
+
+// 返回当前所有表的快照,一次请求中使用同一个快照可以看到一致的数据
+func Snapshot() *Tables {
+	s, _ := __snapshot.Load().(*Tables)
+	return s
+}
+
+// 复制当前快照,修改后替换
+func updateSnapshot(fn func(s *Tables)) {
+	__snapshotMu.Lock()
+	defer __snapshotMu.Unlock()
+	s := &Tables{}
+	if old := Snapshot(); old != nil {
+		*s = *old
+	}
+	fn(s)
+	__snapshot.Store(s)
+}
+
+// 校验跨表引用
+func (s *Tables) validate() error {
+	if err := s.validateModel(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// 校验通过后替换所有表
+func commit(s *Tables) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	__snapshotMu.Lock()
+	defer __snapshotMu.Unlock()
+	__snapshot.Store(s)
+	return nil
+}
+
+// 从dir加载所有表到新的快照,文件名为表名.json,校验跨表引用后一次性替换所有表,出错时不替换任何表
+func LoadAll(dir string) error {
+	s := &Tables{}
+	var err error
+	if s.tModel, err = readModelTable(filepath.Join(dir, "Model.json")); err != nil {
+		return err
+	}
+	return commit(s)
+}